@@ -0,0 +1,193 @@
+package main
+
+import (
+	"aib-pipeline-demo/internal/imagedefinition"
+	"aib-pipeline-demo/internal/imagegallery"
+	"aib-pipeline-demo/internal/imageversion"
+	"aib-pipeline-demo/internal/resourcetags"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/urfave/cli/v2"
+)
+
+func main() {
+	app := &cli.App{
+		Name:  "copy_image_version",
+		Usage: "Copy an image version to a gallery in the same or another subscription",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "sourceVersionID",
+				Usage:    "Resource ID of the image version to copy",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:    "subscriptionID",
+				Aliases: []string{"s"},
+				Usage:   "Azure subscription ID of the target gallery",
+				EnvVars: []string{"AZURE_SUBSCRIPTION_ID"},
+			},
+			&cli.StringFlag{
+				Name:     "resourceGroup",
+				Aliases:  []string{"g"},
+				Usage:    "Azure resource group name of the target gallery",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:     "galleryName",
+				Usage:    "The name of the target image gallery, created if it does not exist",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:  "imageName",
+				Usage: "The name of the target image definition, defaults to the source image definition name",
+			},
+			&cli.StringFlag{
+				Name:  "version",
+				Usage: "The name of the target image version, defaults to the source version name",
+			},
+			&cli.StringFlag{
+				Name:  "location",
+				Usage: "Location of the target gallery and image version, defaults to the location of the source version",
+			},
+			&cli.StringSliceFlag{
+				Name:  "targetRegion",
+				Usage: "Region to replicate the copied version to, defaults to the regions of the source version",
+			},
+			&cli.StringFlag{
+				Name:  "replicationMode",
+				Usage: "Replication mode of the copied version: Full, or Shallow for faster test replication",
+			},
+			&cli.StringSliceFlag{
+				Name:  "osDiskEncryptionSet",
+				Usage: "Disk encryption set for the OS disk in a region, in the format region=diskEncryptionSetID, can be repeated",
+			},
+			&cli.StringSliceFlag{
+				Name:  "cmkRequiredRegion",
+				Usage: "Region which requires a customer-managed key, the copy fails unless it has a disk encryption set",
+			},
+			&cli.StringSliceFlag{
+				Name:  "tag",
+				Usage: "Tag in the format key=value to set on the copied version in addition to the source version's tags",
+			},
+			&cli.DurationFlag{
+				Name:  "replicationTimeout",
+				Usage: "How long to wait for the copied version to be created and replicated",
+				Value: 2 * time.Hour,
+			},
+		},
+		Before: func(c *cli.Context) error {
+			if c.String("subscriptionID") == "" {
+				return cli.Exit("Error: the --subscriptionID flag or AZURE_SUBSCRIPTION_ID environment variable is required", 1)
+			}
+			return nil
+		},
+		Action: copyImageVersion,
+	}
+
+	err := app.Run(os.Args)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func copyImageVersion(c *cli.Context) error {
+	subscriptionID := c.String("subscriptionID")
+	sourceVersionID := c.String("sourceVersionID")
+
+	tags, err := resourcetags.Parse(c.StringSlice("tag"))
+	if err != nil {
+		return fmt.Errorf("error parsing tags: %w", err)
+	}
+
+	regionSettings, err := imageversion.ParseRegionSettings(c.String("replicationMode"), c.StringSlice("osDiskEncryptionSet"), c.StringSlice("cmkRequiredRegion"))
+	if err != nil {
+		return fmt.Errorf("error parsing region settings: %w", err)
+	}
+
+	sourceSubscriptionID, sourceParams, sourceVersionName, err := imageversion.ParseVersionID(sourceVersionID)
+	if err != nil {
+		return err
+	}
+
+	cred, err := azidentity.NewEnvironmentCredential(nil)
+	if err != nil {
+		return fmt.Errorf("failed to setup credentials: %w", err)
+	}
+
+	source, err := imageversion.GetImageVersion(sourceSubscriptionID, cred, sourceParams, sourceVersionName)
+	if err != nil {
+		return fmt.Errorf("error retrieving source image version: %w", err)
+	}
+
+	imageProperties, err := imagedefinition.GetImagePropertiesByID(cred, sourceVersionID)
+	if err != nil {
+		return fmt.Errorf("error retrieving source image definition: %w", err)
+	}
+
+	copyParams := imageversion.CopyParams{
+		Target: imageversion.ImageParams{
+			ResourceGroup: c.String("resourceGroup"),
+			GalleryName:   c.String("galleryName"),
+			ImageName:     c.String("imageName"),
+		},
+		VersionName:   c.String("version"),
+		Location:      c.String("location"),
+		TargetRegions: c.StringSlice("targetRegion"),
+		Tags:          tags,
+		Timeout:       c.Duration("replicationTimeout"),
+	}
+	if copyParams.Target.ImageName == "" {
+		copyParams.Target.ImageName = sourceParams.ImageName
+	}
+	if copyParams.VersionName == "" {
+		copyParams.VersionName = sourceVersionName
+	}
+	if copyParams.Location == "" && source.Location != nil {
+		copyParams.Location = *source.Location
+	}
+
+	version, err := imageversion.BuildCopiedImageVersion(source, copyParams)
+	if err != nil {
+		return fmt.Errorf("error building image version: %w", err)
+	}
+
+	var regionNames []string
+	for _, region := range version.Properties.PublishingProfile.TargetRegions {
+		regionNames = append(regionNames, *region.Name)
+	}
+	if err = regionSettings.Validate(regionNames); err != nil {
+		return fmt.Errorf("error validating region settings: %w", err)
+	}
+	if err = regionSettings.CheckEncryptionSetLocations(cred); err != nil {
+		return fmt.Errorf("error checking disk encryption sets: %w", err)
+	}
+	regionSettings.Apply(&version)
+
+	target := copyParams.Target
+	if err = imagegallery.EnsureImageGallery(subscriptionID, cred, target.ResourceGroup, target.GalleryName, copyParams.Location, nil); err != nil {
+		return fmt.Errorf("error ensuring target gallery: %w", err)
+	}
+
+	// The target image definition must describe the same image, so immutable
+	// differences such as the OS type or security type fail the copy.
+	imageID, err := imagedefinition.EnsureImageDefinition(subscriptionID, cred, target.ResourceGroup, target.GalleryName, target.ImageName, imageProperties, copyParams.Location, nil)
+	if err != nil {
+		return fmt.Errorf("error ensuring target image definition: %w", err)
+	}
+
+	if err = imageversion.EnsureVersionDoesNotExist(cred, fmt.Sprintf("%s/versions/%s", imageID, copyParams.VersionName)); err != nil {
+		return fmt.Errorf("error checking target image version: %w", err)
+	}
+
+	if err = imageversion.CreateImageVersion(subscriptionID, cred, copyParams, version); err != nil {
+		return fmt.Errorf("error copying image version: %w", err)
+	}
+
+	fmt.Printf("Copied %s to %s/versions/%s\n", sourceVersionID, imageID, copyParams.VersionName)
+
+	return nil
+}
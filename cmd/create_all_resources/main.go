@@ -4,12 +4,19 @@ import (
 	"aib-pipeline-demo/internal/imagebuilder"
 	"aib-pipeline-demo/internal/imagedefinition"
 	"aib-pipeline-demo/internal/imagegallery"
+	"aib-pipeline-demo/internal/imageversion"
 	"aib-pipeline-demo/internal/managedidentity"
+	"aib-pipeline-demo/internal/marketplaceimage"
+	"aib-pipeline-demo/internal/marketplaceterms"
+	"aib-pipeline-demo/internal/pipeline"
+	"aib-pipeline-demo/internal/preflight"
 	"aib-pipeline-demo/internal/resourcegroup"
+	"aib-pipeline-demo/internal/resourceprovider"
 	"aib-pipeline-demo/internal/role"
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/urfave/cli/v2"
@@ -19,7 +26,7 @@ func main() {
 	app := &cli.App{
 		Name:  "create_all_resources",
 		Usage: "Create an image template and all required resources to use Azure Image Builder",
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			&cli.StringFlag{
 				Name:    "subscriptionID",
 				Aliases: []string{"s"},
@@ -32,53 +39,6 @@ func main() {
 				Usage:    "Azure resource group name",
 				Required: true,
 			},
-			&cli.StringFlag{
-				Name:     "location",
-				Aliases:  []string{"l"},
-				Usage:    "Location in which to deploy resources",
-				Required: true,
-			},
-			&cli.StringFlag{
-				Name:     "imageTemplateName",
-				Usage:    "Name of the image template to create",
-				Required: true,
-			},
-			&cli.StringFlag{
-				Name:  "runOutputName",
-				Usage: "The Azure Image Builder output name",
-				Value: "aibDemoOutput",
-			},
-			&cli.StringFlag{
-				Name:  "imageName",
-				Usage: "The name of the image definition to create",
-				Value: "aibDemoImage",
-			},
-			&cli.StringFlag{
-				Name:     "galleryName",
-				Usage:    "The name of the image gallery to create",
-				Required: true,
-			},
-			&cli.StringSliceFlag{
-				Name:     "targetRegion",
-				Aliases:  []string{"r"},
-				Usage:    "A region to replicate the produced image to.",
-				Required: true,
-			},
-			&cli.PathFlag{
-				Name:  "rolePermissions",
-				Value: "./config/aibRolePermissions.json",
-				Usage: "Path to the role permissions file",
-			},
-			&cli.PathFlag{
-				Name:  "imageProperties",
-				Value: "./config/imageDefinitionProperties.json",
-				Usage: "Path to the image definitions properties file",
-			},
-			&cli.PathFlag{
-				Name:  "customizations",
-				Value: "./config/customizations.json",
-				Usage: "Path to the image template customizations file",
-			},
 			&cli.BoolFlag{
 				Name:  "exportTemplate",
 				Usage: "Whether the raw iamge template data should be exported",
@@ -89,7 +49,27 @@ func main() {
 				Value: "generatedTemplate.json",
 				Usage: "Path to export the image template to if enabled",
 			},
-		},
+			&cli.BoolFlag{
+				Name:  "skipPermissionCheck",
+				Usage: "Skip checking the caller's permissions before creating any resources",
+				Value: false,
+			},
+			&cli.BoolFlag{
+				Name:  "registerProviders",
+				Usage: "Register any required resource providers that are not registered in the subscription",
+				Value: false,
+			},
+			&cli.BoolFlag{
+				Name:  "acceptTerms",
+				Usage: "Accept the marketplace terms for the purchase plan in the image properties file",
+				Value: false,
+			},
+			&cli.BoolFlag{
+				Name:  "replaceTemplate",
+				Usage: "Delete and recreate the image template if it already exists and differs, for example in its image version",
+				Value: false,
+			},
+		}, pipeline.Flags()...),
 		Before: func(c *cli.Context) error {
 			if c.String("subscriptionID") == "" {
 				return cli.Exit("Error: the --subscriptionID flag or AZURE_SUBSCRIPTION_ID environment variable is required", 1)
@@ -108,20 +88,23 @@ func main() {
 func createAllResources(c *cli.Context) error {
 	subscriptionID := c.String("subscriptionID")
 	resourceGroupName := c.String("resourceGroup")
-	location := c.String("location")
-	imageTemplateName := c.String("imageTemplateName")
-	galleryName := c.String("galleryName")
-	imageName := c.String("imageName")
-
-	targetRegions := c.StringSlice("targetRegion")
-	runOutputName := c.String("runOutputName")
-
-	rolePermissionsFile := c.Path("rolePermissions")
-	imagePropertiesFile := c.Path("imageProperties")
-	customizationsFile := c.Path("customizations")
 
 	exportTemplate := c.Bool("exportTemplate")
 	exportPath := c.Path("exportPath")
+	skipPermissionCheck := c.Bool("skipPermissionCheck")
+	registerProviders := c.Bool("registerProviders")
+	acceptTerms := c.Bool("acceptTerms")
+	replaceTemplate := c.Bool("replaceTemplate")
+
+	config, err := pipeline.ConfigFromContext(c)
+	if err != nil {
+		fmt.Println("Error loading configuration:", err)
+		return err
+	}
+
+	location := config.Location
+	imageProperties := config.ImageProperties
+	buildSettings := config.BuildSettings
 
 	cred, err := azidentity.NewEnvironmentCredential(nil)
 
@@ -130,9 +113,107 @@ func createAllResources(c *cli.Context) error {
 		return err
 	}
 
+	providerNamespaces := resourceprovider.RequiredNamespaces()
+	if buildSettings.ContainerInstanceSubnetID != "" {
+		providerNamespaces = append(providerNamespaces, "Microsoft.ContainerInstance")
+	}
+
+	if !skipPermissionCheck {
+		steps := preflight.CreateAllResourcesSteps()
+		if registerProviders {
+			steps = append(steps, preflight.RegisterProvidersStep(providerNamespaces))
+		}
+		if imageProperties.PurchasePlan != nil {
+			steps = append(steps, preflight.MarketplaceTermsStep())
+			if acceptTerms {
+				steps = append(steps, preflight.AcceptMarketplaceTermsStep())
+			}
+		}
+		if config.ImageVersion != "" {
+			steps = append(steps, preflight.ImageVersionCheckStep())
+		}
+		if config.SharingProfile != nil {
+			steps = append(steps, preflight.GallerySharingStep())
+		}
+
+		missing, err := preflight.CheckPermissions(subscriptionID, cred, resourceGroupName, steps)
+		if err != nil {
+			fmt.Println("Error checking permissions:", err)
+			return err
+		}
+
+		if config.StagingResourceGroup != "" {
+			stagingMissing, err := preflight.CheckPermissions(subscriptionID, cred, config.StagingResourceGroup, []preflight.Step{preflight.StagingResourceGroupStep()})
+			if err != nil {
+				fmt.Println("Error checking permissions:", err)
+				return err
+			}
+			missing = append(missing, stagingMissing...)
+		}
+
+		vnetIDs, err := buildSettings.VirtualNetworkIDs()
+		if err != nil {
+			fmt.Println("Error getting virtual network:", err)
+			return err
+		}
+
+		for _, vnetID := range vnetIDs {
+			networkMissing, err := preflight.CheckResourcePermissions(cred, vnetID, []preflight.Step{preflight.NetworkRoleStep()})
+			if err != nil {
+				fmt.Println("Error checking permissions:", err)
+				return err
+			}
+			missing = append(missing, networkMissing...)
+		}
+
+		if len(missing) > 0 {
+			fmt.Println("The caller is missing permissions required to create all resources:")
+			if err = preflight.PrintMissingPermissions(os.Stdout, missing); err != nil {
+				return err
+			}
+			return fmt.Errorf("missing %d required permissions", len(missing))
+		}
+	}
+
+	err = resourceprovider.EnsureProvidersRegistered(subscriptionID, cred, providerNamespaces, registerProviders)
+	if err != nil {
+		fmt.Println("Error ensuring resource providers are registered:", err)
+		return err
+	}
+
+	err = marketplaceterms.EnsureTermsAccepted(subscriptionID, cred, imageProperties.PurchasePlan, acceptTerms)
+	if err != nil {
+		fmt.Println("Error ensuring marketplace terms are accepted:", err)
+		return err
+	}
+
+	sourceImageParams := marketplaceimage.Params{
+		Location:  location,
+		Publisher: *imageProperties.Identifier.Publisher,
+		Offer:     *imageProperties.Identifier.Offer,
+		SKU:       *imageProperties.Identifier.SKU,
+		Version:   "latest",
+	}
+	sourceImage, err := marketplaceimage.GetImage(subscriptionID, cred, sourceImageParams)
+	if err != nil {
+		fmt.Println("Error retrieving source image:", err)
+		return err
+	}
+
+	expectedProperties := marketplaceimage.BuildImageProperties(imageProperties, sourceImageParams, sourceImage)
+	for _, mismatch := range marketplaceimage.CompareImageProperties(imageProperties, expectedProperties) {
+		log.Println("Warning: image properties contradict the source image:", mismatch)
+	}
+
+	if err = buildSettings.ValidateSourceImage(sourceImage); err != nil {
+		fmt.Println("Error validating source image:", err)
+		return err
+	}
+
 	resourceGroupParams := resourcegroup.Params{
 		Name:     resourceGroupName,
 		Location: location,
+		Tags:     config.Tags,
 	}
 	groupID, err := resourcegroup.EnsureResourceGroup(subscriptionID, cred, resourceGroupParams)
 	if err != nil {
@@ -141,9 +222,10 @@ func createAllResources(c *cli.Context) error {
 	}
 
 	identityParams := managedidentity.UserAssignedIdentityParams{
-		Name:          "aibUserIdentity",
+		Name:          pipeline.IdentityName,
 		ResourceGroup: resourceGroupName,
 		Location:      location,
+		Tags:          config.Tags,
 	}
 	identityData, err := managedidentity.EnsureUserManagedIdentity(subscriptionID, cred, identityParams)
 	if err != nil {
@@ -151,19 +233,7 @@ func createAllResources(c *cli.Context) error {
 		return err
 	}
 
-	permissions, err := role.BuildRolePermissionsFromFile(rolePermissionsFile)
-	if err != nil {
-		fmt.Println("Error importing role permissions from file:", err)
-		return err
-	}
-
-	roleParams := role.DefinitionParams{
-		Name:        "AIB Role Definition",
-		Description: "Role to give Azure Image Builder access to the necessary resources.",
-		Scopes:      []string{groupID},
-	}
-
-	roleProperties := role.BuildRoleProperties(roleParams, permissions)
+	roleProperties := config.RoleProperties(groupID)
 
 	fmt.Printf("Identity ID: %v\n", identityData)
 	roleID, err := role.EnsureRoleDefinition(subscriptionID, cred, roleProperties, groupID)
@@ -179,34 +249,92 @@ func createAllResources(c *cli.Context) error {
 		return err
 	}
 
-	err = imagegallery.EnsureImageGallery(subscriptionID, cred, resourceGroupName, galleryName, location)
+	vnetIDs, err := buildSettings.VirtualNetworkIDs()
 	if err != nil {
-		fmt.Println("Error ensuring shared image gallery:", err)
+		fmt.Println("Error getting virtual network:", err)
 		return err
 	}
 
-	imageProperties, err := imagedefinition.BuildImagePropertiesFromFile(imagePropertiesFile)
+	for _, vnetID := range vnetIDs {
+		networkRoleProperties := config.NetworkRoleProperties(vnetID)
+		networkRoleID, err := role.EnsureRoleDefinition(subscriptionID, cred, networkRoleProperties, vnetID)
+		if err != nil {
+			fmt.Println("Error ensuring network role:", err)
+			return err
+		}
+		fmt.Println("Network role ID:", networkRoleID)
+
+		_, err = role.EnsureRoleAssignment(subscriptionID, cred, vnetID, identityData.PrincipleID, networkRoleID)
+		if err != nil {
+			fmt.Println("Error assigning network role:", err)
+			return err
+		}
+	}
+
+	stagingGroupID := ""
+	if config.StagingResourceGroup != "" {
+		stagingGroupParams := resourcegroup.Params{
+			Name:     config.StagingResourceGroup,
+			Location: location,
+			Tags:     config.Tags,
+		}
+		stagingGroupID, err = resourcegroup.EnsureResourceGroup(subscriptionID, cred, stagingGroupParams)
+		if err != nil {
+			fmt.Println("Error ensuring staging resource group:", err)
+			return err
+		}
+
+		_, err = role.EnsureRoleAssignment(subscriptionID, cred, stagingGroupID, identityData.PrincipleID, role.ContributorRoleID(subscriptionID))
+		if err != nil {
+			fmt.Println("Error assigning role on staging resource group:", err)
+			return err
+		}
+	}
+
+	err = imagegallery.EnsureImageGallery(subscriptionID, cred, resourceGroupName, config.GalleryName, location, config.Tags)
 	if err != nil {
-		fmt.Println("Error getting image properties:", err)
+		fmt.Println("Error ensuring shared image gallery:", err)
 		return err
 	}
 
-	imageID, err := imagedefinition.EnsureImageDefinition(subscriptionID, cred, resourceGroupName, galleryName, imageName, imageProperties, location)
+	if config.SharingProfile != nil {
+		err = imagegallery.EnsureGallerySharing(subscriptionID, cred, resourceGroupName, config.GalleryName, *config.SharingProfile)
+		if err != nil {
+			fmt.Println("Error ensuring gallery sharing:", err)
+			return err
+		}
+	}
+
+	imageID, err := imagedefinition.EnsureImageDefinition(subscriptionID, cred, resourceGroupName, config.GalleryName, config.ImageName, imageProperties, location, config.Tags)
 	if err != nil {
 		fmt.Println("Error ensuring image definition:", err)
 		return err
 	}
 
-	distributeTemplate := imagebuilder.BuildImageTemplateDistributor(imageID, runOutputName, targetRegions)
-	sourceTemplate := imagebuilder.BuildImageTemplateSource(*imageProperties.Identifier.Offer, *imageProperties.Identifier.Publisher, *imageProperties.Identifier.SKU, "latest")
-	imageTemplateCustomizations, err := imagebuilder.BuildImageTemplateCustomizationsFromFile(customizationsFile)
+	deployedTemplate, templateExists, err := imagebuilder.FindImageBuilderTemplate(subscriptionID, cred, resourceGroupName, config.ImageTemplateName)
 	if err != nil {
-		fmt.Println("Error importing customizations:", err)
+		fmt.Println("Error retrieving image builder template:", err)
 		return err
 	}
+	deployedVersion := ""
+	if templateExists {
+		deployedVersion = imagebuilder.ImageTemplateVersion(deployedTemplate)
+		if config.KeepsDeployedVersion() && deployedVersion != "" {
+			config.ImageVersion = deployedVersion
+		}
+	}
+
+	// A version the existing template already targets may have been built
+	// by an earlier run.
+	if config.ImageVersion != "" && !strings.EqualFold(config.ImageVersion, deployedVersion) {
+		err = imageversion.EnsureVersionDoesNotExist(cred, fmt.Sprintf("%s/versions/%s", imageID, config.ImageVersion))
+		if err != nil {
+			fmt.Println("Error checking image version:", err)
+			return err
+		}
+	}
 
-	imageTemplateProperties := imagebuilder.BuildImageTemplateProperties(distributeTemplate, sourceTemplate, imageTemplateCustomizations)
-	imageTemplate := imagebuilder.BuildImageTemplate(identityData.ID, location, imageTemplateProperties)
+	imageTemplate := config.BuildImageTemplate(identityData.ID, imageID, stagingGroupID)
 
 	if exportTemplate {
 		err = imagebuilder.ExportImageTemplateToFile(exportPath, imageTemplate)
@@ -216,7 +344,7 @@ func createAllResources(c *cli.Context) error {
 		}
 	}
 
-	err = imagebuilder.EnsureImageBuilderTemplate(subscriptionID, cred, resourceGroupName, imageTemplateName, imageTemplate)
+	err = imagebuilder.EnsureImageBuilderTemplate(subscriptionID, cred, resourceGroupName, config.ImageTemplateName, imageTemplate, replaceTemplate)
 	if err != nil {
 		fmt.Println("Error ensuring image builder template:", err)
 		return err
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"aib-pipeline-demo/internal/imagebuilder"
+	"aib-pipeline-demo/internal/pipeline"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/urfave/cli/v2"
+)
+
+func main() {
+	app := &cli.App{
+		Name:  "diff_image_template",
+		Usage: "Compare the image template built from the config files with the deployed image template",
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:    "subscriptionID",
+				Aliases: []string{"s"},
+				Usage:   "Azure subscription ID",
+				EnvVars: []string{"AZURE_SUBSCRIPTION_ID"},
+			},
+			&cli.StringFlag{
+				Name:     "resourceGroup",
+				Aliases:  []string{"g"},
+				Usage:    "Azure resource group name",
+				Required: true,
+			},
+		}, pipeline.Flags()...),
+		Before: func(c *cli.Context) error {
+			if c.String("subscriptionID") == "" {
+				return cli.Exit("Error: the --subscriptionID flag or AZURE_SUBSCRIPTION_ID environment variable is required", 1)
+			}
+			return nil
+		},
+		Action: diffImageTemplate,
+	}
+
+	err := app.Run(os.Args)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func diffImageTemplate(c *cli.Context) error {
+	subscriptionID := c.String("subscriptionID")
+	resourceGroupName := c.String("resourceGroup")
+
+	config, err := pipeline.ConfigFromContext(c)
+	if err != nil {
+		return fmt.Errorf("error loading configuration: %w", err)
+	}
+
+	cred, err := azidentity.NewEnvironmentCredential(nil)
+	if err != nil {
+		return fmt.Errorf("failed to setup credentials: %w", err)
+	}
+
+	deployedTemplate, err := imagebuilder.GetImageBuilderTemplate(subscriptionID, cred, resourceGroupName, config.ImageTemplateName)
+	if err != nil {
+		return fmt.Errorf("error retrieving deployed image template: %w", err)
+	}
+
+	if deployedVersion := imagebuilder.ImageTemplateVersion(deployedTemplate); config.KeepsDeployedVersion() && deployedVersion != "" {
+		config.ImageVersion = deployedVersion
+	}
+
+	localTemplate := config.BuildExpectedImageTemplate(subscriptionID, resourceGroupName)
+
+	differences, err := imagebuilder.DiffImageTemplates(localTemplate, deployedTemplate)
+	if err != nil {
+		return fmt.Errorf("error comparing image templates: %w", err)
+	}
+
+	if len(differences) == 0 {
+		log.Println("Deployed image template matches the local configuration:", config.ImageTemplateName)
+		return nil
+	}
+
+	if err = imagebuilder.PrintDifferences(os.Stdout, differences); err != nil {
+		return err
+	}
+
+	return fmt.Errorf("deployed image template differs from the local configuration in %d fields", len(differences))
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"aib-pipeline-demo/internal/pipeline"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/urfave/cli/v2"
+)
+
+func main() {
+	app := &cli.App{
+		Name:  "export_pipeline_template",
+		Usage: "Export the resources created by create_all_resources as an ARM template and Bicep file",
+		Flags: append([]cli.Flag{
+			&cli.PathFlag{
+				Name:  "armTemplatePath",
+				Value: "pipelineTemplate.json",
+				Usage: "Path to write the ARM template to, not written if empty",
+			},
+			&cli.PathFlag{
+				Name:  "bicepPath",
+				Value: "pipelineTemplate.bicep",
+				Usage: "Path to write the Bicep file to, not written if empty",
+			},
+		}, pipeline.Flags()...),
+		Action: exportPipelineTemplate,
+	}
+
+	err := app.Run(os.Args)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func exportPipelineTemplate(c *cli.Context) error {
+	armTemplatePath := c.Path("armTemplatePath")
+	bicepPath := c.Path("bicepPath")
+
+	if armTemplatePath == "" && bicepPath == "" {
+		return fmt.Errorf("at least one of --armTemplatePath or --bicepPath must be set")
+	}
+
+	config, err := pipeline.ConfigFromContext(c)
+	if err != nil {
+		return fmt.Errorf("error loading configuration: %w", err)
+	}
+
+	template, warnings, err := config.BuildARMTemplate()
+	if err != nil {
+		return fmt.Errorf("error building template: %w", err)
+	}
+
+	for _, warning := range warnings {
+		log.Println("Warning:", warning)
+	}
+
+	if armTemplatePath != "" {
+		if err = template.WriteARMFile(armTemplatePath); err != nil {
+			return fmt.Errorf("error writing ARM template: %w", err)
+		}
+		log.Println("Wrote ARM template to:", armTemplatePath)
+	}
+
+	if bicepPath != "" {
+		if err = template.WriteBicepFile(bicepPath); err != nil {
+			return fmt.Errorf("error writing Bicep file: %w", err)
+		}
+		log.Println("Wrote Bicep file to:", bicepPath)
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"aib-pipeline-demo/internal/imagedefinition"
+	"aib-pipeline-demo/internal/marketplaceimage"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/urfave/cli/v2"
+)
+
+func main() {
+	app := &cli.App{
+		Name:  "generate_image_properties",
+		Usage: "Generate the image definition properties file from a marketplace image",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "subscriptionID",
+				Aliases: []string{"s"},
+				Usage:   "Azure subscription ID",
+				EnvVars: []string{"AZURE_SUBSCRIPTION_ID"},
+			},
+			&cli.StringFlag{
+				Name:     "location",
+				Aliases:  []string{"l"},
+				Usage:    "Location in which to look up the marketplace image",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:     "publisher",
+				Usage:    "Publisher of the marketplace image",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:     "offer",
+				Usage:    "Offer of the marketplace image",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:     "sku",
+				Usage:    "SKU of the marketplace image",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:  "version",
+				Usage: "Version of the marketplace image",
+				Value: "latest",
+			},
+			&cli.PathFlag{
+				Name:    "output",
+				Aliases: []string{"o"},
+				Value:   "./config/imageDefinitionProperties.json",
+				Usage:   "Path of the image definition properties file to write",
+			},
+		},
+		Before: func(c *cli.Context) error {
+			if c.String("subscriptionID") == "" {
+				return cli.Exit("Error: the --subscriptionID flag or AZURE_SUBSCRIPTION_ID environment variable is required", 1)
+			}
+			return nil
+		},
+		Action: generateImageProperties,
+	}
+
+	err := app.Run(os.Args)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func generateImageProperties(c *cli.Context) error {
+	subscriptionID := c.String("subscriptionID")
+	outputPath := c.Path("output")
+	params := marketplaceimage.Params{
+		Location:  c.String("location"),
+		Publisher: c.String("publisher"),
+		Offer:     c.String("offer"),
+		SKU:       c.String("sku"),
+		Version:   c.String("version"),
+	}
+
+	cred, err := azidentity.NewEnvironmentCredential(nil)
+	if err != nil {
+		return fmt.Errorf("failed to setup credentials: %w", err)
+	}
+
+	image, err := marketplaceimage.GetImage(subscriptionID, cred, params)
+	if err != nil {
+		return fmt.Errorf("error retrieving marketplace image: %w", err)
+	}
+
+	existing, err := imagedefinition.BuildImagePropertiesFromFile(outputPath)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("error reading existing image properties: %w", err)
+		}
+		existing = armcompute.GalleryImageProperties{}
+	}
+
+	properties := marketplaceimage.BuildImageProperties(existing, params, image)
+	if existing.Identifier != nil {
+		for _, mismatch := range marketplaceimage.CompareImageProperties(existing, properties) {
+			log.Println("Warning: existing image properties contradict the marketplace image:", mismatch)
+		}
+	}
+
+	if err = imagedefinition.ExportImagePropertiesToFile(outputPath, properties); err != nil {
+		return fmt.Errorf("error writing image properties: %w", err)
+	}
+
+	log.Println("Wrote image properties to:", outputPath)
+
+	return nil
+}
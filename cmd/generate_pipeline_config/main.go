@@ -0,0 +1,204 @@
+package main
+
+import (
+	"aib-pipeline-demo/internal/imagebuilder"
+	"aib-pipeline-demo/internal/imagedefinition"
+	"aib-pipeline-demo/internal/managedidentity"
+	"aib-pipeline-demo/internal/pipeline"
+	"aib-pipeline-demo/internal/role"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/authorization/armauthorization/v2"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/virtualmachineimagebuilder/armvirtualmachineimagebuilder/v2"
+	"github.com/urfave/cli/v2"
+)
+
+func main() {
+	app := &cli.App{
+		Name:  "generate_pipeline_config",
+		Usage: "Generate the config files and flags for create_all_resources from an existing image template",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "subscriptionID",
+				Aliases: []string{"s"},
+				Usage:   "Azure subscription ID",
+				EnvVars: []string{"AZURE_SUBSCRIPTION_ID"},
+			},
+			&cli.StringFlag{
+				Name:     "resourceGroup",
+				Aliases:  []string{"g"},
+				Usage:    "Azure resource group name of the image template",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:     "imageTemplateName",
+				Usage:    "Name of the existing image template",
+				Required: true,
+			},
+			&cli.PathFlag{
+				Name:     "outputDir",
+				Aliases:  []string{"o"},
+				Usage:    "Directory to write the config files to",
+				Required: true,
+			},
+		},
+		Before: func(c *cli.Context) error {
+			if c.String("subscriptionID") == "" {
+				return cli.Exit("Error: the --subscriptionID flag or AZURE_SUBSCRIPTION_ID environment variable is required", 1)
+			}
+			return nil
+		},
+		Action: generatePipelineConfig,
+	}
+
+	err := app.Run(os.Args)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func generatePipelineConfig(c *cli.Context) error {
+	subscriptionID := c.String("subscriptionID")
+	resourceGroupName := c.String("resourceGroup")
+	imageTemplateName := c.String("imageTemplateName")
+	outputDir := c.Path("outputDir")
+
+	cred, err := azidentity.NewEnvironmentCredential(nil)
+	if err != nil {
+		return fmt.Errorf("failed to setup credentials: %w", err)
+	}
+
+	template, err := imagebuilder.GetImageBuilderTemplate(subscriptionID, cred, resourceGroupName, imageTemplateName)
+	if err != nil {
+		return fmt.Errorf("error retrieving image template: %w", err)
+	}
+
+	imageIDs := imagebuilder.ImageTemplateGalleryImageIDs(template)
+	if len(imageIDs) != 1 {
+		return fmt.Errorf("image template must distribute to exactly one gallery image, found %d", len(imageIDs))
+	}
+
+	imageProperties, err := imagedefinition.GetImagePropertiesByID(cred, imageIDs[0])
+	if err != nil {
+		return fmt.Errorf("error retrieving image definition: %w", err)
+	}
+
+	config, warnings, err := pipeline.ConfigFromImageTemplate(template, imageProperties)
+	if err != nil {
+		return fmt.Errorf("error converting image template: %w", err)
+	}
+
+	rolePermissions, roleWarnings, err := findRolePermissions(subscriptionID, cred, resourceGroupName, template)
+	if err != nil {
+		return err
+	}
+	warnings = append(warnings, roleWarnings...)
+
+	if err = os.MkdirAll(outputDir, 0o755); err != nil {
+		return fmt.Errorf("error creating output directory: %w", err)
+	}
+
+	customizationsPath := filepath.Join(outputDir, "customizations.json")
+	if err = imagebuilder.ExportCustomizationsToFile(customizationsPath, config.Customizations); err != nil {
+		return fmt.Errorf("error writing customizations: %w", err)
+	}
+
+	imagePropertiesPath := filepath.Join(outputDir, "imageDefinitionProperties.json")
+	if err = imagedefinition.ExportImagePropertiesToFile(imagePropertiesPath, imageProperties); err != nil {
+		return fmt.Errorf("error writing image properties: %w", err)
+	}
+
+	args := []string{"./create_all_resources", "--resourceGroup", resourceGroupName}
+	args = append(args, config.Args()...)
+	args = append(args, "--customizations", customizationsPath, "--imageProperties", imagePropertiesPath)
+
+	if rolePermissions != nil {
+		rolePermissionsPath := filepath.Join(outputDir, "aibRolePermissions.json")
+		if err = role.ExportRolePermissionsToFile(rolePermissionsPath, *rolePermissions); err != nil {
+			return fmt.Errorf("error writing role permissions: %w", err)
+		}
+		args = append(args, "--rolePermissions", rolePermissionsPath)
+	}
+
+	if validate := config.TemplateParams.Validate; validate != nil && len(validate.InVMValidations) > 0 {
+		validationsPath := filepath.Join(outputDir, "validations.json")
+		if err = imagebuilder.ExportValidationsToFile(validationsPath, validate.InVMValidations); err != nil {
+			return fmt.Errorf("error writing validations: %w", err)
+		}
+		args = append(args, "--validations", validationsPath)
+	}
+
+	commandPath := filepath.Join(outputDir, "createAllResources.sh")
+	if err = os.WriteFile(commandPath, []byte(formatCommand(args)), 0o755); err != nil {
+		return fmt.Errorf("error writing command: %w", err)
+	}
+
+	for _, warning := range warnings {
+		log.Println("Warning:", warning)
+	}
+
+	log.Println("Wrote pipeline config to:", outputDir)
+
+	return nil
+}
+
+// findRolePermissions returns the permissions of the custom role assigned to
+// the image template's identity on the resource group. Only a single custom
+// role with a single set of permissions can be written to the role file.
+func findRolePermissions(subscriptionID string, cred azcore.TokenCredential, resourceGroup string, template armvirtualmachineimagebuilder.ImageTemplate) (*armauthorization.Permission, []string, error) {
+	scope := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s", subscriptionID, resourceGroup)
+
+	identityIDs := imagebuilder.ImageTemplateIdentityIDs(template)
+	if len(identityIDs) != 1 {
+		return nil, nil, fmt.Errorf("image template must have exactly one user assigned identity, found %d", len(identityIDs))
+	}
+
+	identityData, err := managedidentity.GetUserManagedIdentity(cred, identityIDs[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("error retrieving identity: %w", err)
+	}
+
+	definitions, err := role.GetAssignedRoleDefinitions(subscriptionID, cred, scope, identityData.PrincipleID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error retrieving role assignments: %w", err)
+	}
+
+	var customRoles []armauthorization.RoleDefinition
+	for _, definition := range definitions {
+		if definition.Properties != nil && definition.Properties.RoleType != nil && *definition.Properties.RoleType == "CustomRole" {
+			customRoles = append(customRoles, definition)
+		}
+	}
+
+	if len(customRoles) != 1 || len(customRoles[0].Properties.Permissions) != 1 {
+		return nil, []string{fmt.Sprintf("found %d custom roles assigned to the identity on the resource group, no role permissions file was written", len(customRoles))}, nil
+	}
+
+	var warnings []string
+	if roleName := customRoles[0].Properties.RoleName; roleName == nil || *roleName != pipeline.RoleName {
+		warnings = append(warnings, fmt.Sprintf("the identity's role is not named %s, create_all_resources will create a new role with the same permissions", pipeline.RoleName))
+	}
+
+	return customRoles[0].Properties.Permissions[0], warnings, nil
+}
+
+// formatCommand writes each flag and its value on its own line of a shell
+// script.
+func formatCommand(args []string) string {
+	lines := []string{args[0]}
+	for _, arg := range args[1:] {
+		if strings.HasPrefix(arg, "--") {
+			lines = append(lines, arg)
+			continue
+		}
+		lines[len(lines)-1] += " '" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+
+	return "#!/bin/sh\n" + strings.Join(lines, " \\\n    ") + "\n"
+}
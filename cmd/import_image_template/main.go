@@ -0,0 +1,102 @@
+package main
+
+import (
+	"aib-pipeline-demo/internal/imagebuilder"
+	"aib-pipeline-demo/internal/imagedefinition"
+	"aib-pipeline-demo/internal/managedidentity"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/urfave/cli/v2"
+)
+
+func main() {
+	app := &cli.App{
+		Name:  "import_image_template",
+		Usage: "Deploy an image template previously exported with create_all_resources",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "subscriptionID",
+				Aliases: []string{"s"},
+				Usage:   "Azure subscription ID",
+				EnvVars: []string{"AZURE_SUBSCRIPTION_ID"},
+			},
+			&cli.StringFlag{
+				Name:     "resourceGroup",
+				Aliases:  []string{"g"},
+				Usage:    "Azure resource group name",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:     "imageTemplateName",
+				Usage:    "Name of the image template to create",
+				Required: true,
+			},
+			&cli.PathFlag{
+				Name:  "templatePath",
+				Value: "generatedTemplate.json",
+				Usage: "Path to the exported image template",
+			},
+			&cli.BoolFlag{
+				Name:  "replace",
+				Usage: "Delete and recreate the image template if it already exists and differs",
+			},
+		},
+		Before: func(c *cli.Context) error {
+			if c.String("subscriptionID") == "" {
+				return cli.Exit("Error: the --subscriptionID flag or AZURE_SUBSCRIPTION_ID environment variable is required", 1)
+			}
+			return nil
+		},
+		Action: importImageTemplate,
+	}
+
+	err := app.Run(os.Args)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func importImageTemplate(c *cli.Context) error {
+	subscriptionID := c.String("subscriptionID")
+	resourceGroupName := c.String("resourceGroup")
+	imageTemplateName := c.String("imageTemplateName")
+	templatePath := c.Path("templatePath")
+	replace := c.Bool("replace")
+
+	imageTemplate, err := imagebuilder.ImportImageTemplateFromFile(templatePath)
+	if err != nil {
+		return fmt.Errorf("error importing image template: %w", err)
+	}
+
+	if err = imagebuilder.ValidateImageTemplate(imageTemplate); err != nil {
+		return fmt.Errorf("invalid image template: %w", err)
+	}
+
+	cred, err := azidentity.NewEnvironmentCredential(nil)
+	if err != nil {
+		return fmt.Errorf("failed to setup credentials: %w", err)
+	}
+
+	for _, identityID := range imagebuilder.ImageTemplateIdentityIDs(imageTemplate) {
+		if _, err = managedidentity.GetUserManagedIdentity(cred, identityID); err != nil {
+			return fmt.Errorf("error resolving image template identity: %w", err)
+		}
+		log.Println("Found identity:", identityID)
+	}
+
+	for _, imageID := range imagebuilder.ImageTemplateGalleryImageIDs(imageTemplate) {
+		if _, err = imagedefinition.GetImageDefinitionByID(cred, imageID); err != nil {
+			return fmt.Errorf("error resolving gallery image: %w", err)
+		}
+		log.Println("Found gallery image:", imageID)
+	}
+
+	if err = imagebuilder.EnsureImageBuilderTemplate(subscriptionID, cred, resourceGroupName, imageTemplateName, imageTemplate, replace); err != nil {
+		return fmt.Errorf("error ensuring image builder template: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"aib-pipeline-demo/internal/inventory"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/urfave/cli/v2"
+)
+
+func main() {
+	app := &cli.App{
+		Name:  "list_images",
+		Usage: "List the image versions in the galleries with their publishing and replication status",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "subscriptionID",
+				Aliases: []string{"s"},
+				Usage:   "Azure subscription ID",
+				EnvVars: []string{"AZURE_SUBSCRIPTION_ID"},
+			},
+			&cli.StringFlag{
+				Name:    "resourceGroup",
+				Aliases: []string{"g"},
+				Usage:   "Azure resource group name, defaults to all resource groups in the subscription",
+			},
+			&cli.StringFlag{
+				Name:  "galleryName",
+				Usage: "Only list the image gallery with this name",
+			},
+			&cli.StringFlag{
+				Name:  "imageName",
+				Usage: "Only list the image definition with this name",
+			},
+			&cli.StringFlag{
+				Name:    "output",
+				Aliases: []string{"o"},
+				Usage:   "The output format, table or json",
+				Value:   "table",
+			},
+		},
+		Before: func(c *cli.Context) error {
+			if c.String("subscriptionID") == "" {
+				return cli.Exit("Error: the --subscriptionID flag or AZURE_SUBSCRIPTION_ID environment variable is required", 1)
+			}
+			if output := c.String("output"); output != "table" && output != "json" {
+				return cli.Exit("Error: the --output flag must be table or json", 1)
+			}
+			return nil
+		},
+		Action: listImages,
+	}
+
+	err := app.Run(os.Args)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func listImages(c *cli.Context) error {
+	subscriptionID := c.String("subscriptionID")
+	filter := inventory.Filter{
+		ResourceGroup: c.String("resourceGroup"),
+		GalleryName:   c.String("galleryName"),
+		ImageName:     c.String("imageName"),
+	}
+
+	cred, err := azidentity.NewEnvironmentCredential(nil)
+	if err != nil {
+		return fmt.Errorf("failed to setup credentials: %w", err)
+	}
+
+	galleries, err := inventory.Collect(subscriptionID, cred, filter)
+	if err != nil {
+		return fmt.Errorf("error listing images: %w", err)
+	}
+
+	if c.String("output") == "json" {
+		return inventory.WriteJSON(os.Stdout, galleries)
+	}
+
+	return inventory.PrintTable(os.Stdout, galleries)
+}
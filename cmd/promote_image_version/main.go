@@ -0,0 +1,52 @@
+package main
+
+import (
+	"aib-pipeline-demo/internal/imageversion"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/urfave/cli/v2"
+)
+
+func main() {
+	app := &cli.App{
+		Name:  "promote_image_version",
+		Usage: "Include an image version in latest so it is used by default",
+		Flags: imageversion.PublishingFlags("The image version to promote", "End of life date in the format YYYY-MM-DD to set on the image version"),
+		Before: func(c *cli.Context) error {
+			if c.String("subscriptionID") == "" {
+				return cli.Exit("Error: the --subscriptionID flag or AZURE_SUBSCRIPTION_ID environment variable is required", 1)
+			}
+			return nil
+		},
+		Action: promoteImageVersion,
+	}
+
+	err := app.Run(os.Args)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func promoteImageVersion(c *cli.Context) error {
+	params, err := imageversion.PublishingParamsFromContext(c)
+	if err != nil {
+		return err
+	}
+
+	cred, err := azidentity.NewEnvironmentCredential(nil)
+	if err != nil {
+		return fmt.Errorf("failed to setup credentials: %w", err)
+	}
+
+	if err = imageversion.PromoteImageVersion(cred, params, time.Now()); err != nil {
+		return fmt.Errorf("error promoting image version: %w", err)
+	}
+
+	log.Println("Promoted image version:", params.VersionName)
+
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"aib-pipeline-demo/internal/imageversion"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/urfave/cli/v2"
+)
+
+func main() {
+	app := &cli.App{
+		Name:  "prune_image_versions",
+		Usage: "Delete old versions of an image definition according to a retention policy",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "subscriptionID",
+				Aliases: []string{"s"},
+				Usage:   "Azure subscription ID",
+				EnvVars: []string{"AZURE_SUBSCRIPTION_ID"},
+			},
+			&cli.StringFlag{
+				Name:     "resourceGroup",
+				Aliases:  []string{"g"},
+				Usage:    "Azure resource group name",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:     "galleryName",
+				Usage:    "The name of the image gallery",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:  "imageName",
+				Usage: "The name of the image definition",
+				Value: "aibDemoImage",
+			},
+			&cli.IntFlag{
+				Name:  "keepLatest",
+				Usage: "Number of newest versions to keep",
+				Value: 3,
+			},
+			&cli.IntFlag{
+				Name:  "keepDays",
+				Usage: "Keep versions published less than this many days ago, disabled if 0",
+			},
+			&cli.BoolFlag{
+				Name:  "allowDeleteAll",
+				Usage: "Delete every version when none of them can be latest, instead of keeping the highest version",
+				Value: false,
+			},
+			&cli.BoolFlag{
+				Name:  "dryRun",
+				Usage: "Only list which versions would be kept and deleted",
+				Value: false,
+			},
+		},
+		Before: func(c *cli.Context) error {
+			if c.String("subscriptionID") == "" {
+				return cli.Exit("Error: the --subscriptionID flag or AZURE_SUBSCRIPTION_ID environment variable is required", 1)
+			}
+			return nil
+		},
+		Action: pruneImageVersions,
+	}
+
+	err := app.Run(os.Args)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func pruneImageVersions(c *cli.Context) error {
+	subscriptionID := c.String("subscriptionID")
+	imageParams := imageversion.ImageParams{
+		ResourceGroup: c.String("resourceGroup"),
+		GalleryName:   c.String("galleryName"),
+		ImageName:     c.String("imageName"),
+	}
+
+	if c.Int("keepLatest") < 0 || c.Int("keepDays") < 0 {
+		return fmt.Errorf("--keepLatest and --keepDays must not be negative")
+	}
+
+	policy := imageversion.RetentionPolicy{
+		KeepLatest:      c.Int("keepLatest"),
+		KeepYoungerThan: time.Duration(c.Int("keepDays")) * 24 * time.Hour,
+		AllowDeleteAll:  c.Bool("allowDeleteAll"),
+	}
+
+	cred, err := azidentity.NewEnvironmentCredential(nil)
+	if err != nil {
+		return fmt.Errorf("failed to setup credentials: %w", err)
+	}
+
+	versions, err := imageversion.ListImageVersions(subscriptionID, cred, imageParams)
+	if err != nil {
+		return fmt.Errorf("error listing image versions: %w", err)
+	}
+
+	decisions := imageversion.PlanPrune(versions, policy, time.Now())
+	if err = imageversion.PrintPrunePlan(os.Stdout, decisions); err != nil {
+		return err
+	}
+
+	if c.Bool("dryRun") {
+		return nil
+	}
+
+	for _, decision := range decisions {
+		if !decision.Delete {
+			continue
+		}
+
+		if err = imageversion.DeleteImageVersion(subscriptionID, cred, imageParams, imageversion.Name(decision.Version)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
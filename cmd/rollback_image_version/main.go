@@ -0,0 +1,124 @@
+package main
+
+import (
+	"aib-pipeline-demo/internal/imageversion"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/urfave/cli/v2"
+)
+
+func main() {
+	app := &cli.App{
+		Name:  "rollback_image_version",
+		Usage: "Exclude the latest image version from latest and restore the previous good version",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "subscriptionID",
+				Aliases: []string{"s"},
+				Usage:   "Azure subscription ID",
+				EnvVars: []string{"AZURE_SUBSCRIPTION_ID"},
+			},
+			&cli.StringFlag{
+				Name:     "resourceGroup",
+				Aliases:  []string{"g"},
+				Usage:    "Azure resource group name",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:     "galleryName",
+				Usage:    "The name of the image gallery",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:  "imageName",
+				Usage: "The name of the image definition",
+				Value: "aibDemoImage",
+			},
+			&cli.StringFlag{
+				Name:  "version",
+				Usage: "The image version to roll back, defaults to the version latest resolves to",
+			},
+			&cli.BoolFlag{
+				Name:  "skipExcluded",
+				Usage: "Fail instead of including a version excluded from latest again when no other good version is left",
+				Value: false,
+			},
+		},
+		Before: func(c *cli.Context) error {
+			if c.String("subscriptionID") == "" {
+				return cli.Exit("Error: the --subscriptionID flag or AZURE_SUBSCRIPTION_ID environment variable is required", 1)
+			}
+			return nil
+		},
+		Action: rollbackImageVersion,
+	}
+
+	err := app.Run(os.Args)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func rollbackImageVersion(c *cli.Context) error {
+	subscriptionID := c.String("subscriptionID")
+	imageParams := imageversion.ImageParams{
+		ResourceGroup: c.String("resourceGroup"),
+		GalleryName:   c.String("galleryName"),
+		ImageName:     c.String("imageName"),
+	}
+
+	cred, err := azidentity.NewEnvironmentCredential(nil)
+	if err != nil {
+		return fmt.Errorf("failed to setup credentials: %w", err)
+	}
+
+	versions, err := imageversion.ListImageVersions(subscriptionID, cred, imageParams)
+	if err != nil {
+		return fmt.Errorf("error listing image versions: %w", err)
+	}
+
+	plan, err := imageversion.PlanRollback(versions, c.String("version"), c.Bool("skipExcluded"), time.Now())
+	if err != nil {
+		return fmt.Errorf("error planning rollback: %w", err)
+	}
+	if imageversion.ExcludedFromLatest(plan.Previous) {
+		log.Printf("Warning: rolling back to %s, which is excluded from latest and will be included again", imageversion.Name(plan.Previous))
+	}
+
+	// The previous version is made eligible first, so latest always resolves
+	// to a version in between the two updates.
+	if imageversion.ExcludedFromLatest(plan.Previous) {
+		excludeFromLatest := false
+		update := imageversion.VersionUpdate{ExcludeFromLatest: &excludeFromLatest}
+		if err = imageversion.UpdateImageVersion(subscriptionID, cred, imageParams, imageversion.Name(plan.Previous), update); err != nil {
+			return fmt.Errorf("error restoring previous version: %w", err)
+		}
+	}
+
+	excludeFromLatest := true
+	update := imageversion.VersionUpdate{
+		ExcludeFromLatest: &excludeFromLatest,
+		Tags:              imageversion.RolledBackTags(),
+	}
+	if err = imageversion.UpdateImageVersion(subscriptionID, cred, imageParams, imageversion.Name(plan.RolledBack), update); err != nil {
+		return fmt.Errorf("error rolling back version: %w", err)
+	}
+
+	versions, err = imageversion.ListImageVersions(subscriptionID, cred, imageParams)
+	if err != nil {
+		return fmt.Errorf("error listing image versions: %w", err)
+	}
+
+	latest, ok := imageversion.LatestVersion(versions)
+	if !ok {
+		return fmt.Errorf("no version is eligible for latest after rolling back %s", imageversion.Name(plan.RolledBack))
+	}
+
+	fmt.Printf("Rolled back %s, latest now resolves to %s\n", imageversion.Name(plan.RolledBack), imageversion.Name(latest))
+
+	return nil
+}
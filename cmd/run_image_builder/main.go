@@ -2,9 +2,11 @@ package main
 
 import (
 	"aib-pipeline-demo/internal/imagebuilder"
+	"aib-pipeline-demo/internal/imageversion"
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/urfave/cli/v2"
@@ -33,6 +35,28 @@ func main() {
 				Usage:    "Azure resource group name",
 				Required: true,
 			},
+			&cli.StringFlag{
+				Name:  "versionScheme",
+				Usage: "How the image version is numbered: auto keeps the version of the template, explicit from --version, date as YYYY.MMDD.HHmm or git from the tag of HEAD",
+				Value: string(imageversion.SchemeAuto),
+			},
+			&cli.StringFlag{
+				Name:  "version",
+				Usage: "The major.minor.patch image version to build with the explicit versioning scheme",
+			},
+			&cli.StringFlag{
+				Name:  "endOfLifeDate",
+				Usage: "End of life date in the format YYYY-MM-DD to set on the produced image version",
+			},
+			&cli.BoolFlag{
+				Name:  "waitForReplication",
+				Usage: "Wait until the produced image version is replicated to every target region",
+			},
+			&cli.DurationFlag{
+				Name:  "replicationTimeout",
+				Usage: "How long to wait for replication with --waitForReplication",
+				Value: 2 * time.Hour,
+			},
 		},
 		Before: func(c *cli.Context) error {
 			if c.String("subscriptionID") == "" {
@@ -54,12 +78,53 @@ func runImageBuilder(c *cli.Context) error {
 	subscriptionID := c.String("subscriptionID")
 	resourceGroupName := c.String("resourceGroupName")
 
+	var endOfLifeDate *time.Time
+	if value := c.String("endOfLifeDate"); value != "" {
+		date, err := imageversion.ParseEndOfLifeDate(value)
+		if err != nil {
+			return err
+		}
+		endOfLifeDate = &date
+	}
+
+	versionScheme, err := imageversion.ParseScheme(c.String("versionScheme"))
+	if err != nil {
+		return fmt.Errorf("error parsing versioning scheme: %w", err)
+	}
+
+	version, err := imageversion.ResolveVersion(versionScheme, c.String("version"), time.Now())
+	if err != nil {
+		return fmt.Errorf("error resolving image version: %w", err)
+	}
+
 	cred, err := azidentity.NewEnvironmentCredential(nil)
 
 	if err != nil {
 		return fmt.Errorf("failed to setup credentials: %w", err)
 	}
 
+	template, err := imagebuilder.GetImageBuilderTemplate(subscriptionID, cred, resourceGroupName, imageTemplateName)
+	if err != nil {
+		return fmt.Errorf("error retrieving image template: %w", err)
+	}
+
+	// The version is part of the immutable template, so the template is
+	// created again when the version resolved for this build differs.
+	replaceTemplate := version != "" && imagebuilder.SetImageTemplateVersion(&template, version)
+
+	for _, versionID := range imageversion.VersionIDs(imagebuilder.ImageTemplateGalleryImageIDs(template)) {
+		if err = imageversion.EnsureVersionDoesNotExist(cred, versionID); err != nil {
+			return fmt.Errorf("error checking image version: %w", err)
+		}
+	}
+
+	if replaceTemplate {
+		log.Printf("Replacing image template %s to build version %s", imageTemplateName, version)
+		if err = imagebuilder.ReplaceImageBuilderTemplate(subscriptionID, cred, resourceGroupName, imageTemplateName, template); err != nil {
+			return fmt.Errorf("error replacing image template: %w", err)
+		}
+	}
+
 	log.Println("Starting image builder for template:", imageTemplateName)
 	if err = imagebuilder.StartImageBuilder(subscriptionID, cred, resourceGroupName, imageTemplateName); err != nil {
 		return fmt.Errorf("error running image builder: %w", err)
@@ -67,5 +132,36 @@ func runImageBuilder(c *cli.Context) error {
 
 	log.Println("Completed image build", imageTemplateName)
 
+	if endOfLifeDate == nil && !c.Bool("waitForReplication") {
+		return nil
+	}
+
+	artifactIDs, err := imagebuilder.GetRunOutputArtifactIDs(subscriptionID, cred, resourceGroupName, imageTemplateName)
+	if err != nil {
+		return fmt.Errorf("error retrieving run outputs: %w", err)
+	}
+
+	for _, versionID := range imageversion.VersionIDs(artifactIDs) {
+		versionSubscriptionID, imageParams, versionName, err := imageversion.ParseVersionID(versionID)
+		if err != nil {
+			return err
+		}
+
+		// The shared image distributor cannot set an end of life date, so it
+		// is set on the version produced by the run.
+		if endOfLifeDate != nil {
+			update := imageversion.VersionUpdate{EndOfLifeDate: endOfLifeDate}
+			if err = imageversion.UpdateImageVersion(versionSubscriptionID, cred, imageParams, versionName, update); err != nil {
+				return fmt.Errorf("error setting end of life date: %w", err)
+			}
+		}
+
+		if c.Bool("waitForReplication") {
+			if err = imageversion.WaitForReplication(versionSubscriptionID, cred, imageParams, versionName, c.Duration("replicationTimeout")); err != nil {
+				return fmt.Errorf("error waiting for replication: %w", err)
+			}
+		}
+	}
+
 	return nil
 }
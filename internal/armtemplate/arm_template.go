@@ -0,0 +1,387 @@
+package armtemplate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const schema = "https://schema.management.azure.com/schemas/2019-04-01/deploymentTemplate.json#"
+
+var bicepIdentifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Expression is a template language expression with its ARM JSON and Bicep
+// forms. ARM expressions are written without the surrounding brackets.
+type Expression struct {
+	ARM   string
+	Bicep string
+}
+
+// Field is a single property of an Object. The key is either a string or an
+// Expression.
+type Field struct {
+	Key   any
+	Value any
+}
+
+// Object keeps the order of its fields so the generated files are stable.
+// Values are strings, bools, json.Number, Expression, Object or []any.
+type Object []Field
+
+type Parameter struct {
+	Name         string
+	Type         string
+	DefaultValue any
+	Description  string
+}
+
+type Resource struct {
+	Symbol     string
+	Type       string
+	APIVersion string
+	// NameSegments holds an ARM expression for each segment of the name, so
+	// child resources have one segment per level.
+	NameSegments []string
+	// BicepName is the name expression in Bicep, which is relative to Parent.
+	BicepName string
+	Parent    *Resource
+	DependsOn []*Resource
+	Body      Object
+}
+
+type Template struct {
+	Parameters []Parameter
+	Resources  []*Resource
+}
+
+func (r *Resource) ID() Expression {
+	return Expression{
+		ARM:   fmt.Sprintf("resourceId('%s', %s)", r.Type, strings.Join(r.NameSegments, ", ")),
+		Bicep: r.Symbol + ".id",
+	}
+}
+
+func (r *Resource) armName() Expression {
+	if len(r.NameSegments) == 1 {
+		return Expression{ARM: r.NameSegments[0]}
+	}
+
+	placeholders := make([]string, len(r.NameSegments))
+	for i := range r.NameSegments {
+		placeholders[i] = fmt.Sprintf("{%d}", i)
+	}
+
+	return Expression{ARM: fmt.Sprintf("format('%s', %s)", strings.Join(placeholders, "/"), strings.Join(r.NameSegments, ", "))}
+}
+
+// ObjectFromJSON converts any value which can be marshaled to JSON into an
+// Object with its keys sorted.
+func ObjectFromJSON(value any) (Object, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling data: %w", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var generic map[string]any
+	if err = decoder.Decode(&generic); err != nil {
+		return nil, fmt.Errorf("error decoding data: %w", err)
+	}
+
+	return convertMap(generic), nil
+}
+
+func convertMap(m map[string]any) Object {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	object := make(Object, 0, len(m))
+	for _, key := range keys {
+		object = append(object, Field{Key: key, Value: convertValue(m[key])})
+	}
+
+	return object
+}
+
+func convertValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		return convertMap(v)
+	case []any:
+		items := make([]any, len(v))
+		for i, item := range v {
+			items[i] = convertValue(item)
+		}
+		return items
+	}
+
+	return value
+}
+
+// Get returns the value of the field with the given string key.
+func (o Object) Get(key string) any {
+	for _, field := range o {
+		if field.Key == key {
+			return field.Value
+		}
+	}
+
+	return nil
+}
+
+// Set replaces the value of the field with the given string key, or appends
+// the field if it does not exist.
+func (o Object) Set(key string, value any) Object {
+	for i, field := range o {
+		if field.Key == key {
+			o[i].Value = value
+			return o
+		}
+	}
+
+	return append(o, Field{Key: key, Value: value})
+}
+
+// Delete removes the field with the given string key.
+func (o Object) Delete(key string) Object {
+	return slices.DeleteFunc(o, func(field Field) bool {
+		return field.Key == key
+	})
+}
+
+func (t Template) WriteARMFile(path string) error {
+	var buf bytes.Buffer
+	parameters := Object{}
+	for _, parameter := range t.Parameters {
+		definition := Object{{Key: "type", Value: parameter.Type}}
+		if parameter.DefaultValue != nil {
+			definition = append(definition, Field{Key: "defaultValue", Value: parameter.DefaultValue})
+		}
+		if parameter.Description != "" {
+			definition = append(definition, Field{Key: "metadata", Value: Object{{Key: "description", Value: parameter.Description}}})
+		}
+		parameters = append(parameters, Field{Key: parameter.Name, Value: definition})
+	}
+
+	var resources []any
+	for _, resource := range t.Resources {
+		armResource := Object{
+			{Key: "type", Value: resource.Type},
+			{Key: "apiVersion", Value: resource.APIVersion},
+			{Key: "name", Value: resource.armName()},
+		}
+		armResource = append(armResource, resource.Body...)
+		if len(resource.DependsOn) > 0 {
+			var dependsOn []any
+			for _, dependency := range resource.DependsOn {
+				dependsOn = append(dependsOn, dependency.ID())
+			}
+			armResource = append(armResource, Field{Key: "dependsOn", Value: dependsOn})
+		}
+		resources = append(resources, armResource)
+	}
+
+	template := Object{
+		{Key: "$schema", Value: schema},
+		{Key: "contentVersion", Value: "1.0.0.0"},
+		{Key: "parameters", Value: parameters},
+		{Key: "resources", Value: resources},
+	}
+
+	if err := writeARMValue(&buf, template, ""); err != nil {
+		return err
+	}
+	buf.WriteString("\n")
+
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("error writing to file: %w", err)
+	}
+
+	return nil
+}
+
+func writeARMValue(buf *bytes.Buffer, value any, indent string) error {
+	switch v := value.(type) {
+	case Object:
+		if len(v) == 0 {
+			buf.WriteString("{}")
+			return nil
+		}
+		buf.WriteString("{\n")
+		for i, field := range v {
+			buf.WriteString(indent + "    ")
+			if err := writeARMValue(buf, field.Key, ""); err != nil {
+				return err
+			}
+			buf.WriteString(": ")
+			if err := writeARMValue(buf, field.Value, indent+"    "); err != nil {
+				return err
+			}
+			if i < len(v)-1 {
+				buf.WriteString(",")
+			}
+			buf.WriteString("\n")
+		}
+		buf.WriteString(indent + "}")
+	case []any:
+		if len(v) == 0 {
+			buf.WriteString("[]")
+			return nil
+		}
+		buf.WriteString("[\n")
+		for i, item := range v {
+			buf.WriteString(indent + "    ")
+			if err := writeARMValue(buf, item, indent+"    "); err != nil {
+				return err
+			}
+			if i < len(v)-1 {
+				buf.WriteString(",")
+			}
+			buf.WriteString("\n")
+		}
+		buf.WriteString(indent + "]")
+	case Expression:
+		data, err := json.Marshal("[" + v.ARM + "]")
+		if err != nil {
+			return fmt.Errorf("error marshaling data: %w", err)
+		}
+		buf.Write(data)
+	case string:
+		// Strings starting with a bracket are escaped so they are not
+		// evaluated as expressions.
+		if strings.HasPrefix(v, "[") {
+			v = "[" + v
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("error marshaling data: %w", err)
+		}
+		buf.Write(data)
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("error marshaling data: %w", err)
+		}
+		buf.Write(data)
+	}
+
+	return nil
+}
+
+func (t Template) WriteBicepFile(path string) error {
+	var buf bytes.Buffer
+	for _, parameter := range t.Parameters {
+		if parameter.Description != "" {
+			fmt.Fprintf(&buf, "@description(%s)\n", bicepString(parameter.Description))
+		}
+		fmt.Fprintf(&buf, "param %s %s", parameter.Name, parameter.Type)
+		if parameter.DefaultValue != nil {
+			buf.WriteString(" = ")
+			writeBicepValue(&buf, parameter.DefaultValue, "")
+		}
+		buf.WriteString("\n\n")
+	}
+
+	for i, resource := range t.Resources {
+		fmt.Fprintf(&buf, "resource %s '%s@%s' = {\n", resource.Symbol, resource.Type, resource.APIVersion)
+		if resource.Parent != nil {
+			fmt.Fprintf(&buf, "  parent: %s\n", resource.Parent.Symbol)
+		}
+		fmt.Fprintf(&buf, "  name: %s\n", resource.BicepName)
+		for _, field := range resource.Body {
+			buf.WriteString("  " + bicepKey(field.Key) + ": ")
+			writeBicepValue(&buf, field.Value, "  ")
+			buf.WriteString("\n")
+		}
+		var dependsOn []string
+		for _, dependency := range resource.DependsOn {
+			if dependency != resource.Parent {
+				dependsOn = append(dependsOn, dependency.Symbol)
+			}
+		}
+		if len(dependsOn) > 0 {
+			buf.WriteString("  dependsOn: [\n")
+			for _, symbol := range dependsOn {
+				buf.WriteString("    " + symbol + "\n")
+			}
+			buf.WriteString("  ]\n")
+		}
+		buf.WriteString("}\n")
+		if i < len(t.Resources)-1 {
+			buf.WriteString("\n")
+		}
+	}
+
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("error writing to file: %w", err)
+	}
+
+	return nil
+}
+
+func writeBicepValue(buf *bytes.Buffer, value any, indent string) {
+	switch v := value.(type) {
+	case Object:
+		if len(v) == 0 {
+			buf.WriteString("{}")
+			return
+		}
+		buf.WriteString("{\n")
+		for _, field := range v {
+			buf.WriteString(indent + "  " + bicepKey(field.Key) + ": ")
+			writeBicepValue(buf, field.Value, indent+"  ")
+			buf.WriteString("\n")
+		}
+		buf.WriteString(indent + "}")
+	case []any:
+		if len(v) == 0 {
+			buf.WriteString("[]")
+			return
+		}
+		buf.WriteString("[\n")
+		for _, item := range v {
+			buf.WriteString(indent + "  ")
+			writeBicepValue(buf, item, indent+"  ")
+			buf.WriteString("\n")
+		}
+		buf.WriteString(indent + "]")
+	case Expression:
+		buf.WriteString(v.Bicep)
+	case string:
+		buf.WriteString(bicepString(v))
+	case json.Number:
+		buf.WriteString(v.String())
+	case nil:
+		buf.WriteString("null")
+	default:
+		fmt.Fprintf(buf, "%v", v)
+	}
+}
+
+func bicepKey(key any) string {
+	switch k := key.(type) {
+	case Expression:
+		return "'${" + k.Bicep + "}'"
+	case string:
+		if bicepIdentifierPattern.MatchString(k) {
+			return k
+		}
+		return bicepString(k)
+	}
+
+	return fmt.Sprintf("%v", key)
+}
+
+func bicepString(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\n", `\n`, "\r", `\r`, "\t", `\t`, "${", `\${`)
+
+	return "'" + replacer.Replace(value) + "'"
+}
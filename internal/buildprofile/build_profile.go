@@ -0,0 +1,327 @@
+package buildprofile
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"aib-pipeline-demo/internal/imagebuilder"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+)
+
+type SecurityType string
+
+const (
+	SecurityTypeStandard               SecurityType = "Standard"
+	SecurityTypeTrustedLaunch          SecurityType = "TrustedLaunch"
+	SecurityTypeTrustedLaunchSupported SecurityType = "TrustedLaunchSupported"
+	SecurityTypeConfidentialVM         SecurityType = "ConfidentialVM"
+	// Images which support confidential VMs are built like confidential VM
+	// images.
+	SecurityTypeConfidentialVMSupported                 SecurityType = "ConfidentialVmSupported"
+	SecurityTypeTrustedLaunchAndConfidentialVMSupported SecurityType = "TrustedLaunchAndConfidentialVmSupported"
+)
+
+var securityTypes = []SecurityType{
+	SecurityTypeStandard,
+	SecurityTypeTrustedLaunch,
+	SecurityTypeTrustedLaunchSupported,
+	SecurityTypeConfidentialVM,
+	SecurityTypeConfidentialVMSupported,
+	SecurityTypeTrustedLaunchAndConfidentialVMSupported,
+}
+
+const securityTypeFeature = "SecurityType"
+
+var (
+	confidentialVMSizePattern = regexp.MustCompile(`(?i)^Standard_[DE]C\d+a\w*_v[56]$`)
+	arm64VMSizePattern        = regexp.MustCompile(`(?i)^Standard_[DE]\d+p\w*_v[56]$`)
+)
+
+type Settings struct {
+	SecurityType              SecurityType
+	Architecture              armcompute.Architecture
+	VMSize                    string
+	OSDiskSizeGB              int32
+	SubnetID                  string
+	ContainerInstanceSubnetID string
+	ProxyVMSize               string
+}
+
+// ParseSecurityType returns an empty security type for an empty value, in
+// which case the SecurityType feature from the image properties file is used.
+func ParseSecurityType(value string) (SecurityType, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	for _, securityType := range securityTypes {
+		if strings.EqualFold(value, string(securityType)) {
+			return securityType, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown security type: %s", value)
+}
+
+// ParseArchitecture returns an empty architecture for an empty value, in which
+// case the architecture from the image properties file is used.
+func ParseArchitecture(value string) (armcompute.Architecture, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	for _, architecture := range armcompute.PossibleArchitectureValues() {
+		if strings.EqualFold(value, string(architecture)) {
+			return architecture, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown architecture: %s", value)
+}
+
+// ImageSecurityType returns the security type set by the SecurityType feature
+// of an image definition, or Standard if it has none.
+func ImageSecurityType(properties armcompute.GalleryImageProperties) (SecurityType, error) {
+	for _, feature := range properties.Features {
+		if feature.Name != nil && feature.Value != nil && strings.EqualFold(*feature.Name, securityTypeFeature) {
+			return ParseSecurityType(*feature.Value)
+		}
+	}
+
+	return SecurityTypeStandard, nil
+}
+
+// BuildVMSize returns the VM size used to build the image. An empty size means
+// the Azure Image Builder default is used.
+func (s Settings) BuildVMSize() string {
+	if s.VMSize != "" {
+		return s.VMSize
+	}
+
+	if s.Architecture == armcompute.ArchitectureArm64 {
+		return "Standard_D2ps_v5"
+	}
+
+	switch {
+	case s.SecurityType.confidential():
+		return "Standard_DC2as_v5"
+	case s.SecurityType == SecurityTypeTrustedLaunch, s.SecurityType == SecurityTypeTrustedLaunchSupported:
+		return "Standard_D2s_v5"
+	}
+
+	return ""
+}
+
+// confidential reports whether the image is built for confidential VMs.
+func (t SecurityType) confidential() bool {
+	return t == SecurityTypeConfidentialVM || t == SecurityTypeConfidentialVMSupported || t == SecurityTypeTrustedLaunchAndConfidentialVMSupported
+}
+
+// ApplyToImageProperties sets the architecture and the SecurityType feature of
+// the image definition when given, replacing the values in the image
+// properties file. Otherwise they are taken from the image definition, so the
+// settings always hold the values in effect.
+func (s *Settings) ApplyToImageProperties(properties *armcompute.GalleryImageProperties) error {
+	switch {
+	case s.Architecture != "":
+		architecture := s.Architecture
+		properties.Architecture = &architecture
+	case properties.Architecture != nil:
+		s.Architecture = *properties.Architecture
+	default:
+		s.Architecture = armcompute.ArchitectureX64
+	}
+
+	if s.SecurityType == "" {
+		securityType, err := ImageSecurityType(*properties)
+		if err != nil {
+			return fmt.Errorf("error reading the image definition security type: %w", err)
+		}
+		s.SecurityType = securityType
+		return nil
+	}
+
+	properties.Features = slices.DeleteFunc(slices.Clone(properties.Features), func(feature *armcompute.GalleryImageFeature) bool {
+		return feature.Name != nil && strings.EqualFold(*feature.Name, securityTypeFeature)
+	})
+
+	if s.SecurityType == SecurityTypeStandard {
+		return nil
+	}
+
+	name := securityTypeFeature
+	value := string(s.SecurityType)
+	properties.Features = append(properties.Features, &armcompute.GalleryImageFeature{
+		Name:  &name,
+		Value: &value,
+	})
+
+	return nil
+}
+
+func (s Settings) VMProfileParams() imagebuilder.VMProfileParams {
+	return imagebuilder.VMProfileParams{
+		VMSize:                    s.BuildVMSize(),
+		OSDiskSizeGB:              s.OSDiskSizeGB,
+		SubnetID:                  s.SubnetID,
+		ContainerInstanceSubnetID: s.ContainerInstanceSubnetID,
+		ProxyVMSize:               s.ProxyVMSize,
+	}
+}
+
+// VirtualNetworkIDs returns the virtual networks containing the build subnets,
+// on which the image builder identity needs join permissions.
+func (s Settings) VirtualNetworkIDs() ([]string, error) {
+	var vnetIDs []string
+	for _, subnetID := range []string{s.SubnetID, s.ContainerInstanceSubnetID} {
+		if subnetID == "" {
+			continue
+		}
+
+		vnetID, err := virtualNetworkID(subnetID)
+		if err != nil {
+			return nil, err
+		}
+
+		if !slices.ContainsFunc(vnetIDs, func(id string) bool { return strings.EqualFold(id, vnetID) }) {
+			vnetIDs = append(vnetIDs, vnetID)
+		}
+	}
+
+	return vnetIDs, nil
+}
+
+func virtualNetworkID(subnetID string) (string, error) {
+	resourceID, err := arm.ParseResourceID(subnetID)
+	if err != nil {
+		return "", fmt.Errorf("invalid subnet ID %s: %w", subnetID, err)
+	}
+
+	if !strings.EqualFold(resourceID.ResourceType.String(), "Microsoft.Network/virtualNetworks/subnets") || resourceID.Parent == nil {
+		return "", fmt.Errorf("not a subnet ID: %s", subnetID)
+	}
+
+	return resourceID.Parent.String(), nil
+}
+
+func (s Settings) validateNetwork() error {
+	if s.OSDiskSizeGB < 0 {
+		return fmt.Errorf("OS disk size must not be negative, got: %d", s.OSDiskSizeGB)
+	}
+
+	if s.SubnetID == "" {
+		if s.ContainerInstanceSubnetID != "" || s.ProxyVMSize != "" {
+			return fmt.Errorf("a subnet ID is required to set the container instance subnet ID or proxy VM size")
+		}
+		return nil
+	}
+
+	if s.ContainerInstanceSubnetID != "" && s.ProxyVMSize != "" {
+		return fmt.Errorf("a proxy VM size cannot be set together with a container instance subnet ID")
+	}
+
+	vnetIDs, err := s.VirtualNetworkIDs()
+	if err != nil {
+		return err
+	}
+
+	if len(vnetIDs) > 1 {
+		return fmt.Errorf("the container instance subnet must be in the same virtual network as the build subnet")
+	}
+
+	return nil
+}
+
+func (s Settings) Validate(properties armcompute.GalleryImageProperties) error {
+	if err := s.validateNetwork(); err != nil {
+		return err
+	}
+
+	isGeneration2 := properties.HyperVGeneration != nil && *properties.HyperVGeneration == armcompute.HyperVGenerationV2
+	vmSize := s.BuildVMSize()
+
+	if s.Architecture == armcompute.ArchitectureArm64 {
+		if !isGeneration2 {
+			return fmt.Errorf("architecture %s requires hyperVGeneration V2", s.Architecture)
+		}
+
+		if !arm64VMSizePattern.MatchString(vmSize) {
+			return fmt.Errorf("architecture %s requires an Arm64 VM size such as Standard_D2ps_v5, got: %s", s.Architecture, vmSize)
+		}
+	} else if vmSize != "" && arm64VMSizePattern.MatchString(vmSize) {
+		return fmt.Errorf("architecture %s cannot be built on an Arm64 VM size, got: %s", s.Architecture, vmSize)
+	}
+
+	if s.SecurityType == SecurityTypeStandard {
+		return nil
+	}
+
+	if !isGeneration2 {
+		return fmt.Errorf("security type %s requires hyperVGeneration V2", s.SecurityType)
+	}
+
+	if s.SecurityType.confidential() {
+		if s.Architecture != armcompute.ArchitectureX64 {
+			return fmt.Errorf("security type %s requires the x64 architecture", s.SecurityType)
+		}
+
+		if !confidentialVMSizePattern.MatchString(vmSize) {
+			return fmt.Errorf("security type %s requires a confidential VM size such as Standard_DC2as_v5, got: %s", s.SecurityType, vmSize)
+		}
+	}
+
+	return nil
+}
+
+// ValidateSourceImage checks that the marketplace image has the same
+// architecture and is compatible with the security type through its generation
+// and SecurityType feature.
+func (s Settings) ValidateSourceImage(image armcompute.VirtualMachineImage) error {
+	if image.Properties == nil {
+		return fmt.Errorf("source image has no properties")
+	}
+
+	imageArchitecture := armcompute.ArchitectureTypesX64
+	if image.Properties.Architecture != nil {
+		imageArchitecture = *image.Properties.Architecture
+	}
+	if !strings.EqualFold(string(imageArchitecture), string(s.Architecture)) {
+		return fmt.Errorf("source image architecture is %s but the image is built for %s", imageArchitecture, s.Architecture)
+	}
+
+	if s.SecurityType == SecurityTypeStandard {
+		return nil
+	}
+
+	if image.Properties.HyperVGeneration == nil || *image.Properties.HyperVGeneration != armcompute.HyperVGenerationTypesV2 {
+		return fmt.Errorf("security type %s requires a generation 2 source image", s.SecurityType)
+	}
+
+	imageSecurityType := ""
+	for _, feature := range image.Properties.Features {
+		if feature.Name != nil && feature.Value != nil && strings.EqualFold(*feature.Name, securityTypeFeature) {
+			imageSecurityType = *feature.Value
+		}
+	}
+
+	var supported []string
+	switch s.SecurityType {
+	case SecurityTypeTrustedLaunch, SecurityTypeTrustedLaunchSupported:
+		// Generation 2 images without a SecurityType feature support Trusted Launch.
+		supported = []string{"", "TrustedLaunch", "TrustedLaunchSupported", "TrustedLaunchAndConfidentialVmSupported"}
+	case SecurityTypeConfidentialVM:
+		supported = []string{"ConfidentialVM", "ConfidentialVmSupported", "TrustedLaunchAndConfidentialVmSupported"}
+	}
+
+	for _, value := range supported {
+		if strings.EqualFold(value, imageSecurityType) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("source image does not support security type %s, image security type: %q", s.SecurityType, imageSecurityType)
+}
@@ -0,0 +1,81 @@
+package buildprofile
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+)
+
+func TestImageSecurityType(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    SecurityType
+		vmSize  string
+		wantErr bool
+	}{
+		{name: "no feature", want: SecurityTypeStandard},
+		{name: "standard", value: "Standard", want: SecurityTypeStandard},
+		{name: "trusted launch", value: "TrustedLaunch", want: SecurityTypeTrustedLaunch, vmSize: "Standard_D2s_v5"},
+		{name: "trusted launch supported", value: "trustedlaunchsupported", want: SecurityTypeTrustedLaunchSupported, vmSize: "Standard_D2s_v5"},
+		{name: "confidential VM", value: "ConfidentialVM", want: SecurityTypeConfidentialVM, vmSize: "Standard_DC2as_v5"},
+		{name: "confidential VM supported", value: "ConfidentialVmSupported", want: SecurityTypeConfidentialVMSupported, vmSize: "Standard_DC2as_v5"},
+		{name: "trusted launch and confidential VM supported", value: "TrustedLaunchAndConfidentialVmSupported", want: SecurityTypeTrustedLaunchAndConfidentialVMSupported, vmSize: "Standard_DC2as_v5"},
+		{name: "unknown", value: "Secure", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var properties armcompute.GalleryImageProperties
+			if test.value != "" {
+				name := "SecurityType"
+				properties.Features = []*armcompute.GalleryImageFeature{{Name: &name, Value: &test.value}}
+			}
+
+			securityType, err := ImageSecurityType(properties)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("ImageSecurityType() = %s, want an error", securityType)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ImageSecurityType() error: %v", err)
+			}
+			if securityType != test.want {
+				t.Errorf("ImageSecurityType() = %s, want %s", securityType, test.want)
+			}
+
+			settings := Settings{SecurityType: securityType, Architecture: armcompute.ArchitectureX64}
+			if vmSize := settings.BuildVMSize(); vmSize != test.vmSize {
+				t.Errorf("BuildVMSize() = %q, want %q", vmSize, test.vmSize)
+			}
+		})
+	}
+}
+
+func TestValidateSecurityType(t *testing.T) {
+	generation2 := armcompute.HyperVGenerationV2
+	tests := []struct {
+		name         string
+		securityType SecurityType
+		architecture armcompute.Architecture
+		vmSize       string
+		wantErr      bool
+	}{
+		{name: "confidential VM supported", securityType: SecurityTypeConfidentialVMSupported, architecture: armcompute.ArchitectureX64},
+		{name: "trusted launch and confidential VM supported", securityType: SecurityTypeTrustedLaunchAndConfidentialVMSupported, architecture: armcompute.ArchitectureX64},
+		{name: "confidential VM supported on a standard size", securityType: SecurityTypeConfidentialVMSupported, architecture: armcompute.ArchitectureX64, vmSize: "Standard_D2s_v5", wantErr: true},
+		{name: "trusted launch and confidential VM supported on Arm64", securityType: SecurityTypeTrustedLaunchAndConfidentialVMSupported, architecture: armcompute.ArchitectureArm64, vmSize: "Standard_D2ps_v5", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings := Settings{SecurityType: test.securityType, Architecture: test.architecture, VMSize: test.vmSize}
+			err := settings.Validate(armcompute.GalleryImageProperties{HyperVGeneration: &generation2})
+			if (err != nil) != test.wantErr {
+				t.Errorf("Validate() error = %v, want error %t", err, test.wantErr)
+			}
+		})
+	}
+}
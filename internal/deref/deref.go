@@ -0,0 +1,11 @@
+package deref
+
+// String returns the value of a string pointer from the Azure SDK, or an empty
+// string if it is nil.
+func String[T ~string](value *T) string {
+	if value == nil {
+		return ""
+	}
+
+	return string(*value)
+}
@@ -1,16 +1,62 @@
 package imagebuilder
 
 import (
+	"aib-pipeline-demo/internal/deref"
+	"aib-pipeline-demo/internal/resourcetags"
 	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"os"
+	"slices"
+	"strings"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/virtualmachineimagebuilder/armvirtualmachineimagebuilder/v2"
 )
 
+type VMProfileParams struct {
+	VMSize                    string
+	OSDiskSizeGB              int32
+	SubnetID                  string
+	ContainerInstanceSubnetID string
+	ProxyVMSize               string
+}
+
+type DistributorParams struct {
+	ImageID       string
+	RunOutputName string
+	TargetRegions []string
+	// Version is the gallery image version to create, Azure Image Builder
+	// numbers the version when it is empty.
+	Version           string
+	ExcludeFromLatest bool
+	ArtifactTags      map[string]*string
+}
+
+type PropertiesParams struct {
+	BuildTimeoutInMinutes int32
+	StagingResourceGroup  string
+	OnCustomizerError     armvirtualmachineimagebuilder.OnBuildError
+	OnValidationError     armvirtualmachineimagebuilder.OnBuildError
+	Validate              *armvirtualmachineimagebuilder.ImageTemplatePropertiesValidate
+}
+
+func ParseOnBuildError(value string) (armvirtualmachineimagebuilder.OnBuildError, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	for _, onBuildError := range armvirtualmachineimagebuilder.PossibleOnBuildErrorValues() {
+		if strings.EqualFold(value, string(onBuildError)) {
+			return onBuildError, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown error handling behavior, expected abort or cleanup: %s", value)
+}
+
 func StartImageBuilder(subscriptionID string, cred azcore.TokenCredential, resourceGroup string, imageTemplateName string) error {
 	clientFactory, err := armvirtualmachineimagebuilder.NewClientFactory(subscriptionID, cred, nil)
 	if err != nil {
@@ -31,7 +77,173 @@ func StartImageBuilder(subscriptionID string, cred azcore.TokenCredential, resou
 	return nil
 }
 
-func EnsureImageBuilderTemplate(subscriptionID string, cred azcore.TokenCredential, resourceGroup string, imageTemplateName string, imageTemplate armvirtualmachineimagebuilder.ImageTemplate) error {
+func GetImageBuilderTemplate(subscriptionID string, cred azcore.TokenCredential, resourceGroup string, imageTemplateName string) (armvirtualmachineimagebuilder.ImageTemplate, error) {
+	clientFactory, err := armvirtualmachineimagebuilder.NewClientFactory(subscriptionID, cred, nil)
+	if err != nil {
+		return armvirtualmachineimagebuilder.ImageTemplate{}, fmt.Errorf("failed to create client factory: %w", err)
+	}
+
+	client := clientFactory.NewVirtualMachineImageTemplatesClient()
+	resp, err := client.Get(context.Background(), resourceGroup, imageTemplateName, nil)
+	if err != nil {
+		return armvirtualmachineimagebuilder.ImageTemplate{}, fmt.Errorf("error while retrieving image template: %w", err)
+	}
+
+	return resp.ImageTemplate, nil
+}
+
+// FindImageBuilderTemplate returns the image template and whether it exists.
+func FindImageBuilderTemplate(subscriptionID string, cred azcore.TokenCredential, resourceGroup string, imageTemplateName string) (armvirtualmachineimagebuilder.ImageTemplate, bool, error) {
+	clientFactory, err := armvirtualmachineimagebuilder.NewClientFactory(subscriptionID, cred, nil)
+	if err != nil {
+		return armvirtualmachineimagebuilder.ImageTemplate{}, false, fmt.Errorf("failed to create client factory: %w", err)
+	}
+
+	client := clientFactory.NewVirtualMachineImageTemplatesClient()
+	resp, err := client.Get(context.Background(), resourceGroup, imageTemplateName, nil)
+	if err != nil {
+		switch e := err.(type) {
+		case *azcore.ResponseError:
+			if e.StatusCode == 404 {
+				return armvirtualmachineimagebuilder.ImageTemplate{}, false, nil
+			}
+			return armvirtualmachineimagebuilder.ImageTemplate{}, false, fmt.Errorf("error while retrieving image template: %w", e)
+		default:
+			return armvirtualmachineimagebuilder.ImageTemplate{}, false, fmt.Errorf("error while retrieving image template: %w", e)
+		}
+	}
+
+	return resp.ImageTemplate, true, nil
+}
+
+// ReplaceImageBuilderTemplate deletes the image template and creates it again,
+// which is the only way to change anything but its tags. The fields set by
+// Azure are removed from the template before it is created.
+func ReplaceImageBuilderTemplate(subscriptionID string, cred azcore.TokenCredential, resourceGroup string, imageTemplateName string, imageTemplate armvirtualmachineimagebuilder.ImageTemplate) error {
+	clientFactory, err := armvirtualmachineimagebuilder.NewClientFactory(subscriptionID, cred, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create client factory: %w", err)
+	}
+
+	client := clientFactory.NewVirtualMachineImageTemplatesClient()
+	if err = deleteImageBuilderTemplate(*client, resourceGroup, imageTemplateName); err != nil {
+		return err
+	}
+
+	return createImageBuilderTemplate(*client, resourceGroup, imageTemplateName, withoutReadOnlyFields(imageTemplate))
+}
+
+func withoutReadOnlyFields(template armvirtualmachineimagebuilder.ImageTemplate) armvirtualmachineimagebuilder.ImageTemplate {
+	template.ID = nil
+	template.Name = nil
+	template.Type = nil
+	template.SystemData = nil
+
+	if template.Identity != nil {
+		identity := *template.Identity
+		identity.UserAssignedIdentities = map[string]*armvirtualmachineimagebuilder.UserAssignedIdentity{}
+		for identityID := range template.Identity.UserAssignedIdentities {
+			identity.UserAssignedIdentities[identityID] = &armvirtualmachineimagebuilder.UserAssignedIdentity{}
+		}
+		template.Identity = &identity
+	}
+
+	if template.Properties != nil {
+		properties := *template.Properties
+		properties.ExactStagingResourceGroup = nil
+		properties.LastRunStatus = nil
+		properties.ProvisioningError = nil
+		properties.ProvisioningState = nil
+		if source, ok := properties.Source.(*armvirtualmachineimagebuilder.ImageTemplatePlatformImageSource); ok {
+			sourceCopy := *source
+			sourceCopy.ExactVersion = nil
+			properties.Source = &sourceCopy
+		}
+		template.Properties = &properties
+	}
+
+	return template
+}
+
+// ImageTemplateVersion returns the gallery image version the template
+// distributes to, empty when Azure Image Builder numbers the version.
+func ImageTemplateVersion(template armvirtualmachineimagebuilder.ImageTemplate) string {
+	for _, imageID := range ImageTemplateGalleryImageIDs(template) {
+		if index := strings.Index(strings.ToLower(imageID), "/versions/"); index >= 0 {
+			return imageID[index+len("/versions/"):]
+		}
+	}
+
+	return ""
+}
+
+// SetImageTemplateVersion points the gallery image distributors of the
+// template to the version and reports whether any of them changed.
+func SetImageTemplateVersion(template *armvirtualmachineimagebuilder.ImageTemplate, version string) bool {
+	if template.Properties == nil {
+		return false
+	}
+
+	changed := false
+	for i, distributor := range template.Properties.Distribute {
+		sharedImage, ok := distributor.(*armvirtualmachineimagebuilder.ImageTemplateSharedImageDistributor)
+		if !ok || sharedImage.GalleryImageID == nil {
+			continue
+		}
+
+		imageID := *sharedImage.GalleryImageID
+		if index := strings.Index(strings.ToLower(imageID), "/versions/"); index >= 0 {
+			imageID = imageID[:index]
+		}
+		galleryImageID := fmt.Sprintf("%s/versions/%s", imageID, version)
+		if strings.EqualFold(galleryImageID, *sharedImage.GalleryImageID) {
+			continue
+		}
+
+		distributorCopy := *sharedImage
+		distributorCopy.GalleryImageID = &galleryImageID
+		template.Properties.Distribute[i] = &distributorCopy
+		changed = true
+	}
+
+	return changed
+}
+
+// GetRunOutputArtifactIDs returns the IDs of the artifacts produced by the last
+// run of the image template.
+func GetRunOutputArtifactIDs(subscriptionID string, cred azcore.TokenCredential, resourceGroup string, imageTemplateName string) ([]string, error) {
+	clientFactory, err := armvirtualmachineimagebuilder.NewClientFactory(subscriptionID, cred, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client factory: %w", err)
+	}
+
+	ctx := context.Background()
+	client := clientFactory.NewVirtualMachineImageTemplatesClient()
+	pager := client.NewListRunOutputsPager(resourceGroup, imageTemplateName, nil)
+
+	var artifactIDs []string
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving run output page: %w", err)
+		}
+
+		for _, runOutput := range page.Value {
+			if runOutput.Properties != nil && runOutput.Properties.ArtifactID != nil {
+				artifactIDs = append(artifactIDs, *runOutput.Properties.ArtifactID)
+			}
+		}
+	}
+
+	return artifactIDs, nil
+}
+
+// EnsureImageBuilderTemplate creates the image template if it does not
+// exist. An existing template can only have its tags changed, so when its
+// source, customizers, distributors or identity differ the differences are
+// printed and it fails, unless replace is set, in which case the template is
+// deleted and created again.
+func EnsureImageBuilderTemplate(subscriptionID string, cred azcore.TokenCredential, resourceGroup string, imageTemplateName string, imageTemplate armvirtualmachineimagebuilder.ImageTemplate, replace bool) error {
 	clientFactory, err := armvirtualmachineimagebuilder.NewClientFactory(subscriptionID, cred, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create client factory: %w", err)
@@ -40,7 +252,7 @@ func EnsureImageBuilderTemplate(subscriptionID string, cred azcore.TokenCredenti
 	client := clientFactory.NewVirtualMachineImageTemplatesClient()
 
 	ctx := context.Background()
-	_, err = client.Get(ctx, resourceGroup, imageTemplateName, nil)
+	existing, err := client.Get(ctx, resourceGroup, imageTemplateName, nil)
 	if err != nil {
 		switch e := err.(type) {
 		case *azcore.ResponseError:
@@ -54,6 +266,59 @@ func EnsureImageBuilderTemplate(subscriptionID string, cred azcore.TokenCredenti
 		}
 	}
 
+	differences, err := DiffImageTemplates(imageTemplate, existing.ImageTemplate)
+	if err != nil {
+		return err
+	}
+	if len(differences) > 0 {
+		if err = PrintDifferences(os.Stdout, differences); err != nil {
+			return fmt.Errorf("error printing differences: %w", err)
+		}
+		if !replace {
+			return fmt.Errorf("image template %s already exists and differs in %d fields, which cannot be updated, delete it or replace it", imageTemplateName, len(differences))
+		}
+
+		log.Print("Replacing image template: ", imageTemplateName)
+		if err = deleteImageBuilderTemplate(*client, resourceGroup, imageTemplateName); err != nil {
+			return err
+		}
+		return createImageBuilderTemplate(*client, resourceGroup, imageTemplateName, imageTemplate)
+	}
+
+	// Tags are the only part of an image template which can be changed.
+	if changes := resourcetags.Drift(existing.Tags, imageTemplate.Tags); len(changes) > 0 {
+		log.Printf("Updating image template %s: %s\n", imageTemplateName, strings.Join(changes, ", "))
+		return updateImageBuilderTemplateTags(*client, resourceGroup, imageTemplateName, resourcetags.Merge(existing.Tags, imageTemplate.Tags))
+	}
+
+	return nil
+}
+
+func deleteImageBuilderTemplate(client armvirtualmachineimagebuilder.VirtualMachineImageTemplatesClient, resourceGroup string, imageTemplateName string) error {
+	ctx := context.Background()
+	poller, err := client.BeginDelete(ctx, resourceGroup, imageTemplateName, nil)
+	if err != nil {
+		return fmt.Errorf("error deleting image template: %w", err)
+	}
+
+	if _, err = poller.PollUntilDone(ctx, nil); err != nil {
+		return fmt.Errorf("error deleting image template: %w", err)
+	}
+
+	return nil
+}
+
+func updateImageBuilderTemplateTags(client armvirtualmachineimagebuilder.VirtualMachineImageTemplatesClient, resourceGroup string, imageTemplateName string, tags map[string]*string) error {
+	ctx := context.Background()
+	poller, err := client.BeginUpdate(ctx, resourceGroup, imageTemplateName, armvirtualmachineimagebuilder.ImageTemplateUpdateParameters{Tags: tags}, nil)
+	if err != nil {
+		return fmt.Errorf("error updating image template: %w", err)
+	}
+
+	if _, err = poller.PollUntilDone(ctx, nil); err != nil {
+		return fmt.Errorf("error updating image template: %w", err)
+	}
+
 	return nil
 }
 
@@ -85,7 +350,7 @@ func BuildImageTemplate(identityID string, location string, properties armvirtua
 	return template
 }
 
-func BuildImageTemplateProperties(distribute armvirtualmachineimagebuilder.ImageTemplateDistributorClassification, source armvirtualmachineimagebuilder.ImageTemplateSourceClassification, customizations []armvirtualmachineimagebuilder.ImageTemplateCustomizerClassification) armvirtualmachineimagebuilder.ImageTemplateProperties {
+func BuildImageTemplateProperties(distribute armvirtualmachineimagebuilder.ImageTemplateDistributorClassification, source armvirtualmachineimagebuilder.ImageTemplateSourceClassification, customizations []armvirtualmachineimagebuilder.ImageTemplateCustomizerClassification, vmProfile *armvirtualmachineimagebuilder.ImageTemplateVMProfile, params PropertiesParams) armvirtualmachineimagebuilder.ImageTemplateProperties {
 	var distributeSlice []armvirtualmachineimagebuilder.ImageTemplateDistributorClassification
 	distributeSlice = append(distributeSlice, distribute)
 
@@ -93,11 +358,60 @@ func BuildImageTemplateProperties(distribute armvirtualmachineimagebuilder.Image
 		Distribute: distributeSlice,
 		Source:     source,
 		Customize:  customizations,
+		VMProfile:  vmProfile,
+		Validate:   params.Validate,
+	}
+
+	if params.BuildTimeoutInMinutes != 0 {
+		properties.BuildTimeoutInMinutes = &params.BuildTimeoutInMinutes
+	}
+	if params.StagingResourceGroup != "" {
+		properties.StagingResourceGroup = &params.StagingResourceGroup
+	}
+
+	if params.OnCustomizerError != "" || params.OnValidationError != "" {
+		errorHandling := armvirtualmachineimagebuilder.ImageTemplatePropertiesErrorHandling{}
+		if params.OnCustomizerError != "" {
+			errorHandling.OnCustomizerError = &params.OnCustomizerError
+		}
+		if params.OnValidationError != "" {
+			errorHandling.OnValidationError = &params.OnValidationError
+		}
+		properties.ErrorHandling = &errorHandling
 	}
 
 	return properties
 }
 
+func BuildImageTemplateVMProfile(params VMProfileParams) *armvirtualmachineimagebuilder.ImageTemplateVMProfile {
+	if params == (VMProfileParams{}) {
+		return nil
+	}
+
+	vmProfile := armvirtualmachineimagebuilder.ImageTemplateVMProfile{}
+	if params.VMSize != "" {
+		vmProfile.VMSize = &params.VMSize
+	}
+	if params.OSDiskSizeGB != 0 {
+		vmProfile.OSDiskSizeGB = &params.OSDiskSizeGB
+	}
+
+	if params.SubnetID != "" {
+		vnetConfig := armvirtualmachineimagebuilder.VirtualNetworkConfig{
+			SubnetID: &params.SubnetID,
+		}
+		if params.ContainerInstanceSubnetID != "" {
+			vnetConfig.ContainerInstanceSubnetID = &params.ContainerInstanceSubnetID
+		}
+		if params.ProxyVMSize != "" {
+			vnetConfig.ProxyVMSize = &params.ProxyVMSize
+		}
+		vmProfile.VnetConfig = &vnetConfig
+	}
+
+	return &vmProfile
+}
+
 func BuildImageIdentityTemplate(identityID string) armvirtualmachineimagebuilder.ImageTemplateIdentity {
 	identityType := armvirtualmachineimagebuilder.ResourceIdentityType("UserAssigned")
 	identities := make(map[string]*armvirtualmachineimagebuilder.UserAssignedIdentity)
@@ -111,34 +425,49 @@ func BuildImageIdentityTemplate(identityID string) armvirtualmachineimagebuilder
 	return identity
 }
 
-func BuildImageTemplateDistributor(imageID string, runOutputName string, targetRegionNames []string) armvirtualmachineimagebuilder.ImageTemplateDistributorClassification {
+func BuildImageTemplateDistributor(params DistributorParams) armvirtualmachineimagebuilder.ImageTemplateDistributorClassification {
 	var targetRegions []*armvirtualmachineimagebuilder.TargetRegion
-	for _, regionName := range targetRegionNames {
+	for _, regionName := range params.TargetRegions {
 		regionNameCopy := regionName
 		region := armvirtualmachineimagebuilder.TargetRegion{Name: &regionNameCopy}
 		targetRegions = append(targetRegions, &region)
 	}
+
+	galleryImageID := params.ImageID
+	if params.Version != "" {
+		galleryImageID = fmt.Sprintf("%s/versions/%s", params.ImageID, params.Version)
+	}
+
 	distribute := armvirtualmachineimagebuilder.ImageTemplateSharedImageDistributor{
-		GalleryImageID: &imageID,
-		RunOutputName:  &runOutputName,
+		GalleryImageID: &galleryImageID,
+		RunOutputName:  &params.RunOutputName,
 		TargetRegions:  targetRegions,
 	}
 
+	if params.ExcludeFromLatest {
+		distribute.ExcludeFromLatest = &params.ExcludeFromLatest
+	}
+	if len(params.ArtifactTags) > 0 {
+		distribute.ArtifactTags = params.ArtifactTags
+	}
+
 	return &distribute
 }
 
-func BuildImageTemplateSource(offer string, publisher string, sku string, version string) armvirtualmachineimagebuilder.ImageTemplateSourceClassification {
-	purchasePlanInfo := armvirtualmachineimagebuilder.PlatformImagePurchasePlan{
-		PlanName:      &sku,
-		PlanProduct:   &offer,
-		PlanPublisher: &publisher,
-	}
+func BuildImageTemplateSource(offer string, publisher string, sku string, version string, plan *armcompute.ImagePurchasePlan) armvirtualmachineimagebuilder.ImageTemplateSourceClassification {
 	source := armvirtualmachineimagebuilder.ImageTemplatePlatformImageSource{
 		Offer:     &offer,
 		Publisher: &publisher,
 		SKU:       &sku,
 		Version:   &version,
-		PlanInfo:  &purchasePlanInfo,
+	}
+
+	if plan != nil {
+		source.PlanInfo = &armvirtualmachineimagebuilder.PlatformImagePurchasePlan{
+			PlanName:      plan.Name,
+			PlanProduct:   plan.Product,
+			PlanPublisher: plan.Publisher,
+		}
 	}
 
 	return &source
@@ -182,6 +511,166 @@ func BuildImageTemplateCustomizationsFromFile(path string) ([]armvirtualmachinei
 	return customizations, nil
 }
 
+func BuildImageTemplateValidate(validations []armvirtualmachineimagebuilder.ImageTemplateInVMValidatorClassification, continueDistributeOnFailure bool, sourceValidationOnly bool) *armvirtualmachineimagebuilder.ImageTemplatePropertiesValidate {
+	if len(validations) == 0 && !continueDistributeOnFailure && !sourceValidationOnly {
+		return nil
+	}
+
+	validate := armvirtualmachineimagebuilder.ImageTemplatePropertiesValidate{
+		InVMValidations:             validations,
+		ContinueDistributeOnFailure: &continueDistributeOnFailure,
+		SourceValidationOnly:        &sourceValidationOnly,
+	}
+
+	return &validate
+}
+
+func BuildImageTemplateValidationsFromFile(path string) ([]armvirtualmachineimagebuilder.ImageTemplateInVMValidatorClassification, error) {
+	var validations []armvirtualmachineimagebuilder.ImageTemplateInVMValidatorClassification
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return validations, fmt.Errorf("error reading file: %s, %w", path, err)
+	}
+
+	var items []json.RawMessage
+	err = json.Unmarshal(data, &items)
+	if err != nil {
+		return validations, fmt.Errorf("error importing from json: %w", err)
+	}
+
+	for _, item := range items {
+		var tempMap map[string]interface{}
+		if err = json.Unmarshal(item, &tempMap); err != nil {
+			return validations, fmt.Errorf("error importing from json: %w", err)
+		}
+
+		switch tempMap["type"] {
+		case "Shell":
+			var obj armvirtualmachineimagebuilder.ImageTemplateShellValidator
+			if err = json.Unmarshal(item, &obj); err != nil {
+				return validations, fmt.Errorf("error importing from json: %w", err)
+			}
+			validations = append(validations, &obj)
+		case "PowerShell":
+			var obj armvirtualmachineimagebuilder.ImageTemplatePowerShellValidator
+			if err = json.Unmarshal(item, &obj); err != nil {
+				return validations, fmt.Errorf("error importing from json: %w", err)
+			}
+			validations = append(validations, &obj)
+		case "File":
+			var obj armvirtualmachineimagebuilder.ImageTemplateFileValidator
+			if err = json.Unmarshal(item, &obj); err != nil {
+				return validations, fmt.Errorf("error importing from json: %w", err)
+			}
+			validations = append(validations, &obj)
+		}
+	}
+
+	return validations, nil
+}
+
+func ImportImageTemplateFromFile(path string) (armvirtualmachineimagebuilder.ImageTemplate, error) {
+	var template armvirtualmachineimagebuilder.ImageTemplate
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return template, fmt.Errorf("error reading file: %s, %w", path, err)
+	}
+
+	if err = json.Unmarshal(data, &template); err != nil {
+		return template, fmt.Errorf("error importing from json: %w", err)
+	}
+
+	return template, nil
+}
+
+// ValidateImageTemplate checks that an imported template has everything
+// needed to deploy it. Customizers and validators of an unknown type are
+// unmarshaled into their base type by the SDK and are rejected.
+func ValidateImageTemplate(template armvirtualmachineimagebuilder.ImageTemplate) error {
+	if template.Location == nil || *template.Location == "" {
+		return fmt.Errorf("image template has no location")
+	}
+
+	if template.Identity == nil || len(template.Identity.UserAssignedIdentities) == 0 {
+		return fmt.Errorf("image template has no user assigned identity")
+	}
+
+	properties := template.Properties
+	if properties == nil {
+		return fmt.Errorf("image template has no properties")
+	}
+
+	if properties.Source == nil {
+		return fmt.Errorf("image template has no source")
+	}
+
+	if len(properties.Distribute) == 0 {
+		return fmt.Errorf("image template has no distributors")
+	}
+
+	for i, customizer := range properties.Customize {
+		if _, ok := customizer.(*armvirtualmachineimagebuilder.ImageTemplateCustomizer); ok {
+			return fmt.Errorf("customizer %d has an unknown type: %s", i, deref.String(customizer.GetImageTemplateCustomizer().Type))
+		}
+	}
+
+	if properties.Validate != nil {
+		for i, validator := range properties.Validate.InVMValidations {
+			if _, ok := validator.(*armvirtualmachineimagebuilder.ImageTemplateInVMValidator); ok {
+				return fmt.Errorf("validator %d has an unknown type: %s", i, deref.String(validator.GetImageTemplateInVMValidator().Type))
+			}
+		}
+	}
+
+	return nil
+}
+
+func ImageTemplateIdentityIDs(template armvirtualmachineimagebuilder.ImageTemplate) []string {
+	var identityIDs []string
+	if template.Identity != nil {
+		for identityID := range template.Identity.UserAssignedIdentities {
+			identityIDs = append(identityIDs, identityID)
+		}
+	}
+	slices.Sort(identityIDs)
+
+	return identityIDs
+}
+
+func ImageTemplateGalleryImageIDs(template armvirtualmachineimagebuilder.ImageTemplate) []string {
+	var imageIDs []string
+	if template.Properties != nil {
+		for _, distributor := range template.Properties.Distribute {
+			if sharedImage, ok := distributor.(*armvirtualmachineimagebuilder.ImageTemplateSharedImageDistributor); ok && sharedImage.GalleryImageID != nil {
+				imageIDs = append(imageIDs, *sharedImage.GalleryImageID)
+			}
+		}
+	}
+
+	return imageIDs
+}
+
+func ExportCustomizationsToFile(path string, customizations []armvirtualmachineimagebuilder.ImageTemplateCustomizerClassification) error {
+	return exportItemsToFile(path, customizations)
+}
+
+func ExportValidationsToFile(path string, validations []armvirtualmachineimagebuilder.ImageTemplateInVMValidatorClassification) error {
+	return exportItemsToFile(path, validations)
+}
+
+func exportItemsToFile(path string, items any) error {
+	jsonData, err := json.MarshalIndent(items, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error marshaling data: %w", err)
+	}
+
+	if err = os.WriteFile(path, append(jsonData, '\n'), 0o644); err != nil {
+		return fmt.Errorf("error writing to file: %w", err)
+	}
+
+	return nil
+}
+
 func ExportImageTemplateToFile(path string, template armvirtualmachineimagebuilder.ImageTemplate) error {
 	jsonData, err := json.MarshalIndent(template, "", "    ")
 	if err != nil {
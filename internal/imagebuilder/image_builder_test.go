@@ -0,0 +1,59 @@
+package imagebuilder
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/virtualmachineimagebuilder/armvirtualmachineimagebuilder/v2"
+)
+
+const testImageID = "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/galleries/gallery/images/image"
+
+func TestSetImageTemplateVersion(t *testing.T) {
+	tests := []struct {
+		name           string
+		galleryImageID string
+		version        string
+		want           string
+		changed        bool
+	}{
+		{
+			name:           "adds a version",
+			galleryImageID: testImageID,
+			version:        "1.0.0",
+			want:           testImageID + "/versions/1.0.0",
+			changed:        true,
+		},
+		{
+			name:           "replaces the version",
+			galleryImageID: testImageID + "/versions/2025.105.930",
+			version:        "2025.106.1000",
+			want:           testImageID + "/versions/2025.106.1000",
+			changed:        true,
+		},
+		{
+			name:           "keeps the same version in another case",
+			galleryImageID: testImageID + "/Versions/1.0.0",
+			version:        "1.0.0",
+			want:           testImageID + "/Versions/1.0.0",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			template := BuildImageTemplate("identity", "westeurope", armvirtualmachineimagebuilder.ImageTemplateProperties{
+				Distribute: []armvirtualmachineimagebuilder.ImageTemplateDistributorClassification{
+					&armvirtualmachineimagebuilder.ImageTemplateSharedImageDistributor{GalleryImageID: &test.galleryImageID},
+				},
+			})
+
+			changed := SetImageTemplateVersion(&template, test.version)
+			imageIDs := ImageTemplateGalleryImageIDs(template)
+			if changed != test.changed || len(imageIDs) != 1 || imageIDs[0] != test.want {
+				t.Errorf("SetImageTemplateVersion() = %t, %v, want %t, %s", changed, imageIDs, test.changed, test.want)
+			}
+			if version := ImageTemplateVersion(template); version != test.version {
+				t.Errorf("ImageTemplateVersion() = %s, want %s", version, test.version)
+			}
+		})
+	}
+}
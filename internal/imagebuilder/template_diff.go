@@ -0,0 +1,231 @@
+package imagebuilder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/virtualmachineimagebuilder/armvirtualmachineimagebuilder/v2"
+)
+
+// Difference is a field of the image template which differs between the
+// locally built and the deployed template. A nil value means the field is
+// only set on the other side.
+type Difference struct {
+	Path     string
+	Local    any
+	Deployed any
+}
+
+// Fields filled in by the service are removed, as are fields the service
+// returns with their default value when they were not set.
+var (
+	readOnlyFields = []string{"exactVersion", "exactStagingResourceGroup", "lastRunStatus", "provisioningError", "provisioningState"}
+	defaultValues  = map[string]any{
+		"replicaCount":                float64(1),
+		"storageAccountType":          "Standard_LRS",
+		"excludeFromLatest":           false,
+		"sha256Checksum":              "",
+		"buildTimeoutInMinutes":       float64(0),
+		"osDiskSizeGB":                float64(0),
+		"vmSize":                      "",
+		"continueDistributeOnFailure": false,
+		"sourceValidationOnly":        false,
+		"onCustomizerError":           "cleanup",
+		"onValidationError":           "cleanup",
+		"autoRun":                     map[string]any{"state": "Disabled"},
+	}
+	resourceIDFields = []string{"galleryImageId", "imageId", "imageVersionId", "stagingResourceGroup", "subnetId", "containerInstanceSubnetId"}
+	// Tag names are case insensitive.
+	tagFields = []string{"artifactTags"}
+)
+
+// DiffImageTemplates compares every property of two image templates, with the
+// customizers in order, and their identity.
+func DiffImageTemplates(local armvirtualmachineimagebuilder.ImageTemplate, deployed armvirtualmachineimagebuilder.ImageTemplate) ([]Difference, error) {
+	localSections, err := normalizeImageTemplate(local)
+	if err != nil {
+		return nil, fmt.Errorf("error normalizing local image template: %w", err)
+	}
+
+	deployedSections, err := normalizeImageTemplate(deployed)
+	if err != nil {
+		return nil, fmt.Errorf("error normalizing deployed image template: %w", err)
+	}
+
+	return diffValues("", localSections, deployedSections), nil
+}
+
+func PrintDifferences(w io.Writer, differences []Difference) error {
+	for _, difference := range differences {
+		var err error
+		switch {
+		case difference.Deployed == nil:
+			_, err = fmt.Fprintf(w, "+ %s: %s (only in local)\n", difference.Path, formatValue(difference.Local))
+		case difference.Local == nil:
+			_, err = fmt.Fprintf(w, "- %s: %s (only in deployed)\n", difference.Path, formatValue(difference.Deployed))
+		default:
+			_, err = fmt.Fprintf(w, "~ %s: local %s, deployed %s\n", difference.Path, formatValue(difference.Local), formatValue(difference.Deployed))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func formatValue(value any) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+
+	return string(data)
+}
+
+func normalizeImageTemplate(template armvirtualmachineimagebuilder.ImageTemplate) (map[string]any, error) {
+	sections := map[string]any{}
+	if template.Properties != nil {
+		generic, err := toGeneric(template.Properties)
+		if err != nil {
+			return nil, err
+		}
+		if properties, ok := normalizeValue(generic).(map[string]any); ok {
+			sections = properties
+		}
+	}
+
+	// Only the identity IDs are compared, their client and principal IDs are
+	// read-only.
+	if template.Identity != nil {
+		var identityIDs []any
+		for _, identityID := range ImageTemplateIdentityIDs(template) {
+			identityIDs = append(identityIDs, strings.ToLower(identityID))
+		}
+		slices.SortFunc(identityIDs, func(a, b any) int { return strings.Compare(a.(string), b.(string)) })
+
+		identity := map[string]any{"userAssignedIdentities": identityIDs}
+		if template.Identity.Type != nil {
+			identity["type"] = string(*template.Identity.Type)
+		}
+		sections["identity"] = identity
+	}
+
+	return sections, nil
+}
+
+func toGeneric(value any) (any, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling data: %w", err)
+	}
+
+	var generic any
+	if err = json.Unmarshal(data, &generic); err != nil {
+		return nil, fmt.Errorf("error unmarshaling data: %w", err)
+	}
+
+	return generic, nil
+}
+
+func normalizeValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, field := range v {
+			if slices.Contains(readOnlyFields, key) || field == nil {
+				delete(v, key)
+				continue
+			}
+			if defaultValue, ok := defaultValues[key]; ok && reflect.DeepEqual(field, defaultValue) {
+				delete(v, key)
+				continue
+			}
+			if id, ok := field.(string); ok && slices.Contains(resourceIDFields, key) {
+				v[key] = strings.ToLower(id)
+				continue
+			}
+			if tags, ok := field.(map[string]any); ok && slices.Contains(tagFields, key) {
+				lowered := map[string]any{}
+				for tagKey, tagValue := range tags {
+					lowered[strings.ToLower(tagKey)] = tagValue
+				}
+				field = lowered
+			}
+			v[key] = normalizeValue(field)
+			if isEmpty(v[key]) {
+				delete(v, key)
+			}
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = normalizeValue(item)
+		}
+	}
+
+	return value
+}
+
+func isEmpty(value any) bool {
+	switch v := value.(type) {
+	case map[string]any:
+		return len(v) == 0
+	case []any:
+		return len(v) == 0
+	}
+
+	return false
+}
+
+func diffValues(path string, local any, deployed any) []Difference {
+	localMap, localIsMap := local.(map[string]any)
+	deployedMap, deployedIsMap := deployed.(map[string]any)
+	if localIsMap && deployedIsMap {
+		var keys []string
+		for key := range localMap {
+			keys = append(keys, key)
+		}
+		for key := range deployedMap {
+			if _, ok := localMap[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		slices.Sort(keys)
+
+		var differences []Difference
+		for _, key := range keys {
+			keyPath := key
+			if path != "" {
+				keyPath = path + "." + key
+			}
+			differences = append(differences, diffValues(keyPath, localMap[key], deployedMap[key])...)
+		}
+		return differences
+	}
+
+	localSlice, localIsSlice := local.([]any)
+	deployedSlice, deployedIsSlice := deployed.([]any)
+	if localIsSlice && deployedIsSlice {
+		var differences []Difference
+		for i := range max(len(localSlice), len(deployedSlice)) {
+			var localItem, deployedItem any
+			if i < len(localSlice) {
+				localItem = localSlice[i]
+			}
+			if i < len(deployedSlice) {
+				deployedItem = deployedSlice[i]
+			}
+			differences = append(differences, diffValues(fmt.Sprintf("%s[%d]", path, i), localItem, deployedItem)...)
+		}
+		return differences
+	}
+
+	if reflect.DeepEqual(local, deployed) {
+		return nil
+	}
+
+	return []Difference{{Path: path, Local: local, Deployed: deployed}}
+}
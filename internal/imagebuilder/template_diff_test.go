@@ -0,0 +1,113 @@
+package imagebuilder
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/virtualmachineimagebuilder/armvirtualmachineimagebuilder/v2"
+)
+
+const (
+	testIdentityID     = "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.ManagedIdentity/userAssignedIdentities/aibUserIdentity"
+	testStagingGroupID = "/subscriptions/sub/resourceGroups/aib-staging"
+)
+
+func buildTestTemplate(vmProfile VMProfileParams, params PropertiesParams) armvirtualmachineimagebuilder.ImageTemplate {
+	distributor := BuildImageTemplateDistributor(DistributorParams{
+		ImageID:       testImageID,
+		RunOutputName: "output",
+		TargetRegions: []string{"westeurope"},
+	})
+	source := BuildImageTemplateSource("offer", "publisher", "sku", "latest", nil)
+	properties := BuildImageTemplateProperties(distributor, source, nil, BuildImageTemplateVMProfile(vmProfile), params)
+
+	return BuildImageTemplate(testIdentityID, "westeurope", properties)
+}
+
+// deployedTestTemplate adds the fields Azure fills in to the template.
+func deployedTestTemplate(template armvirtualmachineimagebuilder.ImageTemplate) armvirtualmachineimagebuilder.ImageTemplate {
+	deployed := template
+	properties := *template.Properties
+	succeeded := armvirtualmachineimagebuilder.ProvisioningStateSucceeded
+	properties.ProvisioningState = &succeeded
+	exactStagingGroup := "/subscriptions/sub/resourceGroups/IT_rg_template_123"
+	properties.ExactStagingResourceGroup = &exactStagingGroup
+	properties.LastRunStatus = &armvirtualmachineimagebuilder.ImageTemplateLastRunStatus{}
+	if properties.BuildTimeoutInMinutes == nil {
+		buildTimeout := int32(0)
+		properties.BuildTimeoutInMinutes = &buildTimeout
+	}
+	if properties.VMProfile == nil {
+		vmSize := ""
+		osDiskSizeGB := int32(0)
+		properties.VMProfile = &armvirtualmachineimagebuilder.ImageTemplateVMProfile{VMSize: &vmSize, OSDiskSizeGB: &osDiskSizeGB}
+	}
+	if properties.StagingResourceGroup != nil {
+		stagingGroup := strings.ToUpper(*properties.StagingResourceGroup)
+		properties.StagingResourceGroup = &stagingGroup
+	}
+	deployed.Properties = &properties
+
+	return deployed
+}
+
+func TestDiffImageTemplates(t *testing.T) {
+	tests := []struct {
+		name      string
+		local     armvirtualmachineimagebuilder.ImageTemplate
+		deployed  armvirtualmachineimagebuilder.ImageTemplate
+		wantPaths []string
+	}{
+		{
+			name:     "ignores read-only fields and defaults",
+			local:    buildTestTemplate(VMProfileParams{}, PropertiesParams{}),
+			deployed: deployedTestTemplate(buildTestTemplate(VMProfileParams{}, PropertiesParams{})),
+		},
+		{
+			name:     "ignores the casing of the staging resource group",
+			local:    buildTestTemplate(VMProfileParams{}, PropertiesParams{StagingResourceGroup: testStagingGroupID}),
+			deployed: deployedTestTemplate(buildTestTemplate(VMProfileParams{}, PropertiesParams{StagingResourceGroup: testStagingGroupID})),
+		},
+		{
+			name:      "changed VM size",
+			local:     buildTestTemplate(VMProfileParams{VMSize: "Standard_D4s_v5"}, PropertiesParams{}),
+			deployed:  deployedTestTemplate(buildTestTemplate(VMProfileParams{VMSize: "Standard_D2s_v5"}, PropertiesParams{})),
+			wantPaths: []string{"vmProfile.vmSize"},
+		},
+		{
+			name:      "VM size only in local",
+			local:     buildTestTemplate(VMProfileParams{VMSize: "Standard_D4s_v5"}, PropertiesParams{}),
+			deployed:  deployedTestTemplate(buildTestTemplate(VMProfileParams{}, PropertiesParams{})),
+			wantPaths: []string{"vmProfile"},
+		},
+		{
+			name:      "changed build timeout and staging resource group",
+			local:     buildTestTemplate(VMProfileParams{}, PropertiesParams{BuildTimeoutInMinutes: 120, StagingResourceGroup: testStagingGroupID}),
+			deployed:  deployedTestTemplate(buildTestTemplate(VMProfileParams{}, PropertiesParams{})),
+			wantPaths: []string{"buildTimeoutInMinutes", "stagingResourceGroup"},
+		},
+		{
+			name:      "changed error handling",
+			local:     buildTestTemplate(VMProfileParams{}, PropertiesParams{OnCustomizerError: armvirtualmachineimagebuilder.OnBuildErrorAbort}),
+			deployed:  deployedTestTemplate(buildTestTemplate(VMProfileParams{}, PropertiesParams{OnCustomizerError: armvirtualmachineimagebuilder.OnBuildErrorCleanup})),
+			wantPaths: []string{"errorHandling"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			differences, err := DiffImageTemplates(test.local, test.deployed)
+			if err != nil {
+				t.Fatalf("DiffImageTemplates() error: %v", err)
+			}
+
+			var paths []string
+			for _, difference := range differences {
+				paths = append(paths, difference.Path)
+			}
+			if strings.Join(paths, ",") != strings.Join(test.wantPaths, ",") {
+				t.Errorf("DiffImageTemplates() differs in %v, want %v", paths, test.wantPaths)
+			}
+		})
+	}
+}
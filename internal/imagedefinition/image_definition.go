@@ -1,17 +1,29 @@
 package imagedefinition
 
 import (
+	"aib-pipeline-demo/internal/deref"
+	"aib-pipeline-demo/internal/resourcetags"
 	"context"
 	"encoding/json"
 	"fmt"
 	"log"
+	"maps"
 	"os"
+	"reflect"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
 )
 
+const (
+	securityTypeFeature = "SecurityType"
+	defaultSecurityType = "Standard"
+)
+
 func BuildImagePropertiesFromFile(path string) (armcompute.GalleryImageProperties, error) {
 	var properties armcompute.GalleryImageProperties
 	propertiesData, err := os.ReadFile(path)
@@ -27,19 +39,32 @@ func BuildImagePropertiesFromFile(path string) (armcompute.GalleryImagePropertie
 	return properties, nil
 }
 
-func EnsureImageDefinition(subscriptionID string, cred azcore.TokenCredential, resourceGroup string, galleryName string, imageName string, imageProperties armcompute.GalleryImageProperties, location string) (string, error) {
+func ExportImagePropertiesToFile(path string, properties armcompute.GalleryImageProperties) error {
+	jsonData, err := json.MarshalIndent(properties, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error marshaling data: %w", err)
+	}
+
+	if err = os.WriteFile(path, append(jsonData, '\n'), 0o644); err != nil {
+		return fmt.Errorf("error writing to file: %w", err)
+	}
+
+	return nil
+}
+
+func EnsureImageDefinition(subscriptionID string, cred azcore.TokenCredential, resourceGroup string, galleryName string, imageName string, imageProperties armcompute.GalleryImageProperties, location string, tags map[string]*string) (string, error) {
 	clientFactory, err := armcompute.NewClientFactory(subscriptionID, cred, nil)
 	if err != nil {
 		return "", fmt.Errorf("failed to create client factory: %w", err)
 	}
 	client := clientFactory.NewGalleryImagesClient()
-	imageID, err := findImageDefinition(*client, resourceGroup, galleryName, imageName)
+	image, err := findImageDefinition(*client, resourceGroup, galleryName, imageName)
 	if err != nil {
 		switch e := err.(type) {
 		case *azcore.ResponseError:
 			if e.StatusCode == 404 {
 				log.Println("Creating image definition:", imageName)
-				return createImageDefinition(*client, resourceGroup, galleryName, imageName, imageProperties, location)
+				return createImageDefinition(*client, resourceGroup, galleryName, imageName, imageProperties, location, tags)
 			}
 			return "", fmt.Errorf("error while retrieving image gallery: %w", e)
 		default:
@@ -47,25 +72,123 @@ func EnsureImageDefinition(subscriptionID string, cred azcore.TokenCredential, r
 		}
 	}
 
-	return imageID, nil
+	existingProperties := armcompute.GalleryImageProperties{}
+	if image.Properties != nil {
+		existingProperties = *image.Properties
+	}
+
+	if mismatches := findImmutableDrift(existingProperties, imageProperties); len(mismatches) > 0 {
+		return "", fmt.Errorf("image definition %s has immutable fields which differ from the image properties: %s", imageName, strings.Join(mismatches, "; "))
+	}
+
+	changes := findMutableDrift(existingProperties, imageProperties)
+	changes = append(changes, resourcetags.Drift(image.Tags, tags)...)
+	if len(changes) > 0 {
+		log.Printf("Updating image definition %s: %s\n", imageName, strings.Join(changes, ", "))
+		updatedProperties := mergeMutableProperties(existingProperties, imageProperties)
+		return updateImageDefinition(*client, resourceGroup, galleryName, imageName, updatedProperties, resourcetags.Merge(image.Tags, tags))
+	}
+
+	return *image.ID, nil
+}
+
+// GetImageDefinitionByID resolves an image definition ID, or the ID of one of
+// its versions, to the ID of the image definition.
+func GetImageDefinitionByID(cred azcore.TokenCredential, imageID string) (string, error) {
+	image, err := getImageDefinitionByID(cred, imageID)
+	if err != nil {
+		return "", err
+	}
+
+	return *image.ID, nil
+}
+
+// GetImagePropertiesByID returns the properties of an image definition without
+// its read-only fields, so they can be exported to an image properties file.
+func GetImagePropertiesByID(cred azcore.TokenCredential, imageID string) (armcompute.GalleryImageProperties, error) {
+	image, err := getImageDefinitionByID(cred, imageID)
+	if err != nil {
+		return armcompute.GalleryImageProperties{}, err
+	}
+
+	if image.Properties == nil {
+		return armcompute.GalleryImageProperties{}, fmt.Errorf("image definition %s has no properties", imageID)
+	}
+
+	properties := *image.Properties
+	properties.ProvisioningState = nil
+
+	return properties, nil
+}
+
+func getImageDefinitionByID(cred azcore.TokenCredential, imageID string) (armcompute.GalleryImage, error) {
+	resourceID, err := arm.ParseResourceID(imageID)
+	if err != nil {
+		return armcompute.GalleryImage{}, fmt.Errorf("invalid image definition ID %s: %w", imageID, err)
+	}
+
+	if strings.EqualFold(resourceID.ResourceType.String(), "Microsoft.Compute/galleries/images/versions") {
+		resourceID = resourceID.Parent
+	}
+
+	if !strings.EqualFold(resourceID.ResourceType.String(), "Microsoft.Compute/galleries/images") {
+		return armcompute.GalleryImage{}, fmt.Errorf("not an image definition ID: %s", imageID)
+	}
+
+	clientFactory, err := armcompute.NewClientFactory(resourceID.SubscriptionID, cred, nil)
+	if err != nil {
+		return armcompute.GalleryImage{}, fmt.Errorf("failed to create client factory: %w", err)
+	}
+	client := clientFactory.NewGalleryImagesClient()
+
+	image, err := findImageDefinition(*client, resourceID.ResourceGroupName, resourceID.Parent.Name, resourceID.Name)
+	if err != nil {
+		return armcompute.GalleryImage{}, fmt.Errorf("error retrieving image definition %s: %w", imageID, err)
+	}
+
+	return image, nil
+}
+
+func ListImageDefinitions(subscriptionID string, cred azcore.TokenCredential, resourceGroup string, galleryName string) ([]armcompute.GalleryImage, error) {
+	clientFactory, err := armcompute.NewClientFactory(subscriptionID, cred, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client factory: %w", err)
+	}
+	client := clientFactory.NewGalleryImagesClient()
+
+	ctx := context.Background()
+	var definitions []armcompute.GalleryImage
+	pager := client.NewListByGalleryPager(resourceGroup, galleryName, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving image definition page: %w", err)
+		}
+		for _, definition := range page.Value {
+			definitions = append(definitions, *definition)
+		}
+	}
+
+	return definitions, nil
 }
 
-func findImageDefinition(client armcompute.GalleryImagesClient, resourceGroup string, galleryName string, imageName string) (string, error) {
+func findImageDefinition(client armcompute.GalleryImagesClient, resourceGroup string, galleryName string, imageName string) (armcompute.GalleryImage, error) {
 	ctx := context.Background()
 
 	resp, err := client.Get(ctx, resourceGroup, galleryName, imageName, nil)
 	if err != nil {
-		return "", err
+		return armcompute.GalleryImage{}, err
 	}
 
-	return *resp.ID, nil
+	return resp.GalleryImage, nil
 }
 
-func createImageDefinition(client armcompute.GalleryImagesClient, resourceGroup string, galleryName string, imageName string, imageProperties armcompute.GalleryImageProperties, location string) (string, error) {
+func createImageDefinition(client armcompute.GalleryImagesClient, resourceGroup string, galleryName string, imageName string, imageProperties armcompute.GalleryImageProperties, location string, tags map[string]*string) (string, error) {
 	ctx := context.Background()
 	galleryImage := armcompute.GalleryImage{
 		Location:   &location,
 		Properties: &imageProperties,
+		Tags:       tags,
 	}
 
 	poller, err := client.BeginCreateOrUpdate(ctx, resourceGroup, galleryName, imageName, galleryImage, nil)
@@ -86,3 +209,173 @@ func createImageDefinition(client armcompute.GalleryImagesClient, resourceGroup
 
 	return *resp.ID, nil
 }
+
+func updateImageDefinition(client armcompute.GalleryImagesClient, resourceGroup string, galleryName string, imageName string, imageProperties armcompute.GalleryImageProperties, tags map[string]*string) (string, error) {
+	ctx := context.Background()
+	galleryImage := armcompute.GalleryImageUpdate{
+		Properties: &imageProperties,
+		Tags:       tags,
+	}
+
+	poller, err := client.BeginUpdate(ctx, resourceGroup, galleryName, imageName, galleryImage, nil)
+	if err != nil {
+		return "", fmt.Errorf("error updating image definition: %w", err)
+	}
+
+	pollCtx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	resp, err := poller.PollUntilDone(pollCtx, nil)
+	if err != nil {
+		if err == context.DeadlineExceeded {
+			return "", fmt.Errorf("polling timeout exceeded: %w", err)
+		}
+		return "", fmt.Errorf("error while updating image definition: %w", err)
+	}
+
+	return *resp.ID, nil
+}
+
+// findImmutableDrift lists the fields which can only be set when the image
+// definition is created. Unset generation and architecture fall back to the
+// defaults Azure uses.
+func findImmutableDrift(existing armcompute.GalleryImageProperties, desired armcompute.GalleryImageProperties) []string {
+	var mismatches []string
+	check := func(field string, existingValue string, desiredValue string) {
+		if !strings.EqualFold(existingValue, desiredValue) {
+			mismatches = append(mismatches, fmt.Sprintf("%s is %q but the file has %q", field, existingValue, desiredValue))
+		}
+	}
+
+	var existingIdentifier, desiredIdentifier armcompute.GalleryImageIdentifier
+	if existing.Identifier != nil {
+		existingIdentifier = *existing.Identifier
+	}
+	if desired.Identifier != nil {
+		desiredIdentifier = *desired.Identifier
+	}
+	check("identifier.publisher", deref.String(existingIdentifier.Publisher), deref.String(desiredIdentifier.Publisher))
+	check("identifier.offer", deref.String(existingIdentifier.Offer), deref.String(desiredIdentifier.Offer))
+	check("identifier.sku", deref.String(existingIdentifier.SKU), deref.String(desiredIdentifier.SKU))
+
+	check("osType", deref.String(existing.OSType), deref.String(desired.OSType))
+	check("osState", deref.String(existing.OSState), deref.String(desired.OSState))
+	check("hyperVGeneration", valueOrDefault(existing.HyperVGeneration, armcompute.HyperVGenerationV1), valueOrDefault(desired.HyperVGeneration, armcompute.HyperVGenerationV1))
+	check("architecture", valueOrDefault(existing.Architecture, armcompute.ArchitectureX64), valueOrDefault(desired.Architecture, armcompute.ArchitectureX64))
+
+	var existingPlan, desiredPlan armcompute.ImagePurchasePlan
+	if existing.PurchasePlan != nil {
+		existingPlan = *existing.PurchasePlan
+	}
+	if desired.PurchasePlan != nil {
+		desiredPlan = *desired.PurchasePlan
+	}
+	check("purchasePlan.name", deref.String(existingPlan.Name), deref.String(desiredPlan.Name))
+	check("purchasePlan.product", deref.String(existingPlan.Product), deref.String(desiredPlan.Product))
+	check("purchasePlan.publisher", deref.String(existingPlan.Publisher), deref.String(desiredPlan.Publisher))
+
+	// Features which are not in the file are not compared, except for the
+	// security type, which is Standard when it is not set.
+	existingFeatures := featureValues(existing.Features)
+	desiredFeatures := featureValues(desired.Features)
+	for _, name := range slices.Sorted(maps.Keys(desiredFeatures)) {
+		if name != strings.ToLower(securityTypeFeature) {
+			check("features."+name, existingFeatures[name], desiredFeatures[name])
+		}
+	}
+	check("features."+securityTypeFeature, securityType(existingFeatures), securityType(desiredFeatures))
+
+	return mismatches
+}
+
+// featureValues maps the lowercase feature names to their values.
+func featureValues(features []*armcompute.GalleryImageFeature) map[string]string {
+	values := map[string]string{}
+	for _, feature := range features {
+		if feature != nil && feature.Name != nil {
+			values[strings.ToLower(*feature.Name)] = deref.String(feature.Value)
+		}
+	}
+
+	return values
+}
+
+func securityType(featureValues map[string]string) string {
+	if value, ok := featureValues[strings.ToLower(securityTypeFeature)]; ok && value != "" {
+		return value
+	}
+
+	return defaultSecurityType
+}
+
+// findMutableDrift lists the fields which can be updated on an existing image
+// definition. Fields which are not set in the file are left unchanged.
+func findMutableDrift(existing armcompute.GalleryImageProperties, desired armcompute.GalleryImageProperties) []string {
+	var changes []string
+	check := func(field string, existingValue any, desiredValue any) {
+		if !reflect.ValueOf(desiredValue).IsNil() && !jsonEqual(existingValue, desiredValue) {
+			changes = append(changes, field)
+		}
+	}
+
+	check("description", existing.Description, desired.Description)
+	check("eula", existing.Eula, desired.Eula)
+	check("privacyStatementUri", existing.PrivacyStatementURI, desired.PrivacyStatementURI)
+	check("releaseNoteUri", existing.ReleaseNoteURI, desired.ReleaseNoteURI)
+	check("recommended", existing.Recommended, desired.Recommended)
+	check("disallowed", existing.Disallowed, desired.Disallowed)
+	check("allowUpdateImage", existing.AllowUpdateImage, desired.AllowUpdateImage)
+
+	if desired.EndOfLifeDate != nil && (existing.EndOfLifeDate == nil || !existing.EndOfLifeDate.Equal(*desired.EndOfLifeDate)) {
+		changes = append(changes, "endOfLifeDate")
+	}
+
+	return changes
+}
+
+func mergeMutableProperties(existing armcompute.GalleryImageProperties, desired armcompute.GalleryImageProperties) armcompute.GalleryImageProperties {
+	merged := existing
+	merged.ProvisioningState = nil
+
+	if desired.Description != nil {
+		merged.Description = desired.Description
+	}
+	if desired.Eula != nil {
+		merged.Eula = desired.Eula
+	}
+	if desired.PrivacyStatementURI != nil {
+		merged.PrivacyStatementURI = desired.PrivacyStatementURI
+	}
+	if desired.ReleaseNoteURI != nil {
+		merged.ReleaseNoteURI = desired.ReleaseNoteURI
+	}
+	if desired.Recommended != nil {
+		merged.Recommended = desired.Recommended
+	}
+	if desired.Disallowed != nil {
+		merged.Disallowed = desired.Disallowed
+	}
+	if desired.AllowUpdateImage != nil {
+		merged.AllowUpdateImage = desired.AllowUpdateImage
+	}
+	if desired.EndOfLifeDate != nil {
+		merged.EndOfLifeDate = desired.EndOfLifeDate
+	}
+
+	return merged
+}
+
+func jsonEqual(a any, b any) bool {
+	aData, aErr := json.Marshal(a)
+	bData, bErr := json.Marshal(b)
+
+	return aErr == nil && bErr == nil && string(aData) == string(bData)
+}
+
+func valueOrDefault[T ~string](value *T, defaultValue T) string {
+	if value == nil {
+		return string(defaultValue)
+	}
+
+	return string(*value)
+}
@@ -0,0 +1,69 @@
+package imagedefinition
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+)
+
+func features(nameValues ...string) []*armcompute.GalleryImageFeature {
+	var result []*armcompute.GalleryImageFeature
+	for i := 0; i+1 < len(nameValues); i += 2 {
+		result = append(result, &armcompute.GalleryImageFeature{Name: &nameValues[i], Value: &nameValues[i+1]})
+	}
+
+	return result
+}
+
+func TestFindImmutableDriftFeatures(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing []*armcompute.GalleryImageFeature
+		desired  []*armcompute.GalleryImageFeature
+		want     []string
+	}{
+		{
+			name:     "same features in another case",
+			existing: features("SecurityType", "TrustedLaunch", "IsAcceleratedNetworkSupported", "true"),
+			desired:  features("securitytype", "trustedlaunch", "IsAcceleratedNetworkSupported", "True"),
+		},
+		{
+			name:     "changed security type",
+			existing: features("SecurityType", "TrustedLaunch"),
+			desired:  features("SecurityType", "ConfidentialVM"),
+			want:     []string{`features.SecurityType is "TrustedLaunch" but the file has "ConfidentialVM"`},
+		},
+		{
+			name:     "security type removed from the file",
+			existing: features("SecurityType", "TrustedLaunch"),
+			want:     []string{`features.SecurityType is "TrustedLaunch" but the file has "Standard"`},
+		},
+		{
+			name:     "standard security type matches no feature",
+			existing: features("SecurityType", "Standard"),
+		},
+		{
+			name:     "features not in the file are not compared",
+			existing: features("IsAcceleratedNetworkSupported", "true"),
+		},
+		{
+			name:     "added feature",
+			existing: nil,
+			desired:  features("IsHibernateSupported", "true"),
+			want:     []string{`features.ishibernatesupported is "" but the file has "true"`},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mismatches := findImmutableDrift(
+				armcompute.GalleryImageProperties{Features: test.existing},
+				armcompute.GalleryImageProperties{Features: test.desired},
+			)
+			if !slices.Equal(mismatches, test.want) {
+				t.Errorf("findImmutableDrift() = %q, want %q", mismatches, test.want)
+			}
+		})
+	}
+}
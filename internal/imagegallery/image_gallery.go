@@ -1,29 +1,31 @@
 package imagegallery
 
 import (
+	"aib-pipeline-demo/internal/resourcetags"
 	"context"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
 )
 
-func EnsureImageGallery(subscriptionID string, cred azcore.TokenCredential, resourceGroup string, galleryName string, location string) error {
+func EnsureImageGallery(subscriptionID string, cred azcore.TokenCredential, resourceGroup string, galleryName string, location string, tags map[string]*string) error {
 	clientFactory, err := armcompute.NewClientFactory(subscriptionID, cred, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create client factory: %w", err)
 	}
 	client := clientFactory.NewGalleriesClient()
 
-	err = findImageGallery(*client, resourceGroup, galleryName)
+	gallery, err := findImageGallery(*client, resourceGroup, galleryName)
 	if err != nil {
 		switch e := err.(type) {
 		case *azcore.ResponseError:
 			if e.StatusCode == 404 {
 				log.Print("Creating image gallery: ", galleryName)
-				return createImageGallery(*client, resourceGroup, galleryName, location)
+				return createImageGallery(*client, resourceGroup, galleryName, location, tags)
 			}
 			return fmt.Errorf("error while retrieving image gallery: %w", e)
 		default:
@@ -31,13 +33,19 @@ func EnsureImageGallery(subscriptionID string, cred azcore.TokenCredential, reso
 		}
 	}
 
+	if changes := resourcetags.Drift(gallery.Tags, tags); len(changes) > 0 {
+		log.Printf("Updating image gallery %s: %s\n", galleryName, strings.Join(changes, ", "))
+		return updateImageGalleryTags(*client, resourceGroup, galleryName, resourcetags.Merge(gallery.Tags, tags))
+	}
+
 	return nil
 }
 
-func createImageGallery(client armcompute.GalleriesClient, resourceGroup string, galleryName string, location string) error {
+func createImageGallery(client armcompute.GalleriesClient, resourceGroup string, galleryName string, location string, tags map[string]*string) error {
 	ctx := context.Background()
 	gallery := armcompute.Gallery{
 		Location: &location,
+		Tags:     tags,
 	}
 	poller, err := client.BeginCreateOrUpdate(ctx, resourceGroup, galleryName, gallery, nil)
 	if err != nil {
@@ -59,12 +67,73 @@ func createImageGallery(client armcompute.GalleriesClient, resourceGroup string,
 	return nil
 }
 
-func findImageGallery(client armcompute.GalleriesClient, resourceGroup string, galleryName string) error {
-	ctx := context.Background()
-	_, err := client.Get(ctx, resourceGroup, galleryName, nil)
+func updateImageGalleryTags(client armcompute.GalleriesClient, resourceGroup string, galleryName string, tags map[string]*string) error {
+	poller, err := client.BeginUpdate(context.Background(), resourceGroup, galleryName, armcompute.GalleryUpdate{Tags: tags}, nil)
 	if err != nil {
-		return err
+		return fmt.Errorf("error updating gallery: %w", err)
+	}
+
+	pollCtx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	_, err = poller.PollUntilDone(pollCtx, nil)
+	if err != nil {
+		if err == context.DeadlineExceeded {
+			return fmt.Errorf("polling timeout exceeded: %w", err)
+		}
+
+		return fmt.Errorf("error while updating gallery: %w", err)
 	}
 
 	return nil
 }
+
+func findImageGallery(client armcompute.GalleriesClient, resourceGroup string, galleryName string) (armcompute.Gallery, error) {
+	ctx := context.Background()
+	resp, err := client.Get(ctx, resourceGroup, galleryName, nil)
+	if err != nil {
+		return armcompute.Gallery{}, err
+	}
+
+	return resp.Gallery, nil
+}
+
+// ListImageGalleries returns the galleries in the resource group, or in the
+// subscription if resourceGroup is empty.
+func ListImageGalleries(subscriptionID string, cred azcore.TokenCredential, resourceGroup string) ([]armcompute.Gallery, error) {
+	clientFactory, err := armcompute.NewClientFactory(subscriptionID, cred, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client factory: %w", err)
+	}
+	client := clientFactory.NewGalleriesClient()
+
+	ctx := context.Background()
+	var galleries []armcompute.Gallery
+	if resourceGroup == "" {
+		pager := client.NewListPager(nil)
+		for pager.More() {
+			page, err := pager.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("error retrieving gallery page: %w", err)
+			}
+			for _, gallery := range page.Value {
+				galleries = append(galleries, *gallery)
+			}
+		}
+
+		return galleries, nil
+	}
+
+	pager := client.NewListByResourceGroupPager(resourceGroup, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving gallery page: %w", err)
+		}
+		for _, gallery := range page.Value {
+			galleries = append(galleries, *gallery)
+		}
+	}
+
+	return galleries, nil
+}
@@ -0,0 +1,268 @@
+package imagegallery
+
+import (
+	"aib-pipeline-demo/internal/deref"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+)
+
+// BuildSharingProfileFromFile reads the gallery sharing profile, either
+// Groups permissions with the subscriptions and tenants to share with, or
+// Community permissions with the community gallery info.
+func BuildSharingProfileFromFile(path string) (armcompute.SharingProfile, error) {
+	var profile armcompute.SharingProfile
+	profileData, err := os.ReadFile(path)
+	if err != nil {
+		return profile, fmt.Errorf("error reading file: %w", err)
+	}
+
+	if err = json.Unmarshal(profileData, &profile); err != nil {
+		return profile, fmt.Errorf("error importing from json: %w", err)
+	}
+
+	if err = ValidateSharingProfile(profile); err != nil {
+		return profile, err
+	}
+
+	return profile, nil
+}
+
+func ValidateSharingProfile(profile armcompute.SharingProfile) error {
+	if profile.Permissions == nil {
+		return fmt.Errorf("sharing profile must set permissions to Private, Groups or Community")
+	}
+
+	switch *profile.Permissions {
+	case armcompute.GallerySharingPermissionTypesPrivate:
+		if len(profile.Groups) > 0 || profile.CommunityGalleryInfo != nil {
+			return fmt.Errorf("a Private sharing profile cannot have groups or community gallery info")
+		}
+	case armcompute.GallerySharingPermissionTypesGroups:
+		if profile.CommunityGalleryInfo != nil {
+			return fmt.Errorf("a Groups sharing profile cannot have community gallery info")
+		}
+		if len(profile.Groups) == 0 {
+			return fmt.Errorf("a Groups sharing profile must have at least one group")
+		}
+		for _, group := range profile.Groups {
+			if group == nil || group.Type == nil || !slices.Contains(armcompute.PossibleSharingProfileGroupTypesValues(), *group.Type) {
+				return fmt.Errorf("sharing profile group type must be Subscriptions or AADTenants")
+			}
+			if len(group.IDs) == 0 {
+				return fmt.Errorf("sharing profile group %s must have at least one ID", *group.Type)
+			}
+		}
+	case armcompute.GallerySharingPermissionTypesCommunity:
+		if len(profile.Groups) > 0 {
+			return fmt.Errorf("a Community sharing profile cannot have groups")
+		}
+		info := profile.CommunityGalleryInfo
+		if info == nil || info.PublisherURI == nil || info.PublisherContact == nil || info.Eula == nil || info.PublicNamePrefix == nil {
+			return fmt.Errorf("a Community sharing profile must set the publisherUri, publisherContact, eula and publicNamePrefix of the community gallery info")
+		}
+	default:
+		return fmt.Errorf("unknown sharing permissions, expected Private, Groups or Community: %s", *profile.Permissions)
+	}
+
+	return nil
+}
+
+// EnsureGallerySharing reconciles the sharing of the gallery with the profile
+// through the gallery sharing profile API: groups missing from the gallery
+// are added and groups not in the profile are removed.
+func EnsureGallerySharing(subscriptionID string, cred azcore.TokenCredential, resourceGroup string, galleryName string, profile armcompute.SharingProfile) error {
+	clientFactory, err := armcompute.NewClientFactory(subscriptionID, cred, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create client factory: %w", err)
+	}
+	client := clientFactory.NewGalleriesClient()
+	sharingClient := clientFactory.NewGallerySharingProfileClient()
+
+	selectPermissions := armcompute.SelectPermissionsPermissions
+	resp, err := client.Get(context.Background(), resourceGroup, galleryName, &armcompute.GalleriesClientGetOptions{Select: &selectPermissions})
+	if err != nil {
+		return fmt.Errorf("error retrieving gallery sharing profile: %w", err)
+	}
+
+	existing := armcompute.SharingProfile{}
+	if resp.Properties != nil && resp.Properties.SharingProfile != nil {
+		existing = *resp.Properties.SharingProfile
+	}
+	existingPermissions := armcompute.GallerySharingPermissionTypesPrivate
+	if existing.Permissions != nil {
+		existingPermissions = *existing.Permissions
+	}
+	permissions := *profile.Permissions
+
+	// Switching between Groups and Community sharing goes through Private.
+	if existingPermissions != permissions && existingPermissions != armcompute.GallerySharingPermissionTypesPrivate {
+		log.Printf("Resetting sharing of gallery %s from %s", galleryName, existingPermissions)
+		if err = updateSharing(*sharingClient, resourceGroup, galleryName, armcompute.SharingUpdateOperationTypesReset, nil); err != nil {
+			return err
+		}
+		existing = armcompute.SharingProfile{}
+	}
+
+	switch permissions {
+	case armcompute.GallerySharingPermissionTypesGroups:
+		added, removed := diffGroups(existing.Groups, profile.Groups)
+		if len(removed) > 0 {
+			log.Printf("Removing %s from sharing of gallery %s", describeGroups(removed), galleryName)
+			if err = updateSharing(*sharingClient, resourceGroup, galleryName, armcompute.SharingUpdateOperationTypesRemove, removed); err != nil {
+				return err
+			}
+		}
+		if len(added) > 0 {
+			log.Printf("Sharing gallery %s with %s", galleryName, describeGroups(added))
+			if err = updateSharing(*sharingClient, resourceGroup, galleryName, armcompute.SharingUpdateOperationTypesAdd, added); err != nil {
+				return err
+			}
+		}
+	case armcompute.GallerySharingPermissionTypesCommunity:
+		if !communityInfoEqual(existing.CommunityGalleryInfo, profile.CommunityGalleryInfo) {
+			log.Printf("Updating community gallery info of gallery %s", galleryName)
+			if err = updateCommunityGalleryInfo(*client, resourceGroup, galleryName, profile); err != nil {
+				return err
+			}
+		}
+		if existing.CommunityGalleryInfo == nil || existing.CommunityGalleryInfo.CommunityGalleryEnabled == nil || !*existing.CommunityGalleryInfo.CommunityGalleryEnabled {
+			log.Printf("Sharing gallery %s as a community gallery", galleryName)
+			if err = updateSharing(*sharingClient, resourceGroup, galleryName, armcompute.SharingUpdateOperationTypesEnableCommunity, nil); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func updateSharing(client armcompute.GallerySharingProfileClient, resourceGroup string, galleryName string, operation armcompute.SharingUpdateOperationTypes, groups []*armcompute.SharingProfileGroup) error {
+	update := armcompute.SharingUpdate{
+		OperationType: &operation,
+		Groups:        groups,
+	}
+	poller, err := client.BeginUpdate(context.Background(), resourceGroup, galleryName, update, nil)
+	if err != nil {
+		return fmt.Errorf("error updating gallery sharing (%s): %w", operation, err)
+	}
+
+	pollCtx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	if _, err = poller.PollUntilDone(pollCtx, nil); err != nil {
+		if err == context.DeadlineExceeded {
+			return fmt.Errorf("polling timeout exceeded: %w", err)
+		}
+
+		return fmt.Errorf("error updating gallery sharing (%s): %w", operation, err)
+	}
+
+	return nil
+}
+
+func updateCommunityGalleryInfo(client armcompute.GalleriesClient, resourceGroup string, galleryName string, profile armcompute.SharingProfile) error {
+	galleryUpdate := armcompute.GalleryUpdate{
+		Properties: &armcompute.GalleryProperties{
+			SharingProfile: &armcompute.SharingProfile{
+				Permissions:          profile.Permissions,
+				CommunityGalleryInfo: profile.CommunityGalleryInfo,
+			},
+		},
+	}
+	poller, err := client.BeginUpdate(context.Background(), resourceGroup, galleryName, galleryUpdate, nil)
+	if err != nil {
+		return fmt.Errorf("error updating gallery: %w", err)
+	}
+
+	pollCtx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	if _, err = poller.PollUntilDone(pollCtx, nil); err != nil {
+		if err == context.DeadlineExceeded {
+			return fmt.Errorf("polling timeout exceeded: %w", err)
+		}
+
+		return fmt.Errorf("error updating gallery: %w", err)
+	}
+
+	return nil
+}
+
+// diffGroups returns the groups to add to and remove from the existing
+// groups to match the desired groups. IDs are compared case insensitively.
+func diffGroups(existing []*armcompute.SharingProfileGroup, desired []*armcompute.SharingProfileGroup) ([]*armcompute.SharingProfileGroup, []*armcompute.SharingProfileGroup) {
+	existingIDs := groupIDs(existing)
+	desiredIDs := groupIDs(desired)
+
+	var added, removed []*armcompute.SharingProfileGroup
+	for _, groupType := range armcompute.PossibleSharingProfileGroupTypesValues() {
+		if ids := missingIDs(desiredIDs[groupType], existingIDs[groupType]); len(ids) > 0 {
+			added = append(added, &armcompute.SharingProfileGroup{Type: &groupType, IDs: ids})
+		}
+		if ids := missingIDs(existingIDs[groupType], desiredIDs[groupType]); len(ids) > 0 {
+			removed = append(removed, &armcompute.SharingProfileGroup{Type: &groupType, IDs: ids})
+		}
+	}
+
+	return added, removed
+}
+
+func groupIDs(groups []*armcompute.SharingProfileGroup) map[armcompute.SharingProfileGroupTypes][]string {
+	ids := map[armcompute.SharingProfileGroupTypes][]string{}
+	for _, group := range groups {
+		if group == nil || group.Type == nil {
+			continue
+		}
+		for _, id := range group.IDs {
+			if id != nil {
+				ids[*group.Type] = append(ids[*group.Type], *id)
+			}
+		}
+	}
+
+	return ids
+}
+
+// missingIDs returns the IDs in ids which are not in other.
+func missingIDs(ids []string, other []string) []*string {
+	var missing []*string
+	for _, id := range ids {
+		found := slices.ContainsFunc(other, func(otherID string) bool {
+			return strings.EqualFold(id, otherID)
+		})
+		if !found {
+			missing = append(missing, &id)
+		}
+	}
+
+	return missing
+}
+
+func describeGroups(groups []*armcompute.SharingProfileGroup) string {
+	var parts []string
+	for _, group := range groups {
+		parts = append(parts, fmt.Sprintf("%d %s", len(group.IDs), *group.Type))
+	}
+
+	return strings.Join(parts, " and ")
+}
+
+func communityInfoEqual(existing *armcompute.CommunityGalleryInfo, desired *armcompute.CommunityGalleryInfo) bool {
+	if existing == nil || desired == nil {
+		return existing == desired
+	}
+
+	return deref.String(existing.PublisherURI) == deref.String(desired.PublisherURI) &&
+		deref.String(existing.PublisherContact) == deref.String(desired.PublisherContact) &&
+		deref.String(existing.Eula) == deref.String(desired.Eula) &&
+		deref.String(existing.PublicNamePrefix) == deref.String(desired.PublicNamePrefix)
+}
@@ -0,0 +1,129 @@
+package imageversion
+
+import (
+	"aib-pipeline-demo/internal/resourcetags"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+)
+
+type CopyParams struct {
+	Target      ImageParams
+	VersionName string
+	Location    string
+	// TargetRegions defaults to the regions of the source version.
+	TargetRegions []string
+	Tags          map[string]*string
+	Timeout       time.Duration
+}
+
+// BuildCopiedImageVersion builds an image version created from the source
+// version, with the same publishing settings and tags. The source version's
+// region encryption is not kept, as disk encryption sets cannot be used
+// outside of their own subscription and region.
+func BuildCopiedImageVersion(source armcompute.GalleryImageVersion, params CopyParams) (armcompute.GalleryImageVersion, error) {
+	if source.ID == nil {
+		return armcompute.GalleryImageVersion{}, fmt.Errorf("source image version has no ID")
+	}
+
+	publishingProfile := armcompute.GalleryImageVersionPublishingProfile{}
+	var sourceRegions []*armcompute.TargetRegion
+	if source.Properties != nil && source.Properties.PublishingProfile != nil {
+		sourceProfile := source.Properties.PublishingProfile
+		publishingProfile.ExcludeFromLatest = sourceProfile.ExcludeFromLatest
+		publishingProfile.EndOfLifeDate = sourceProfile.EndOfLifeDate
+		publishingProfile.ReplicaCount = sourceProfile.ReplicaCount
+		publishingProfile.StorageAccountType = sourceProfile.StorageAccountType
+		sourceRegions = sourceProfile.TargetRegions
+	}
+
+	regionNames := params.TargetRegions
+	if len(regionNames) == 0 {
+		for _, region := range sourceRegions {
+			if region != nil && region.Name != nil {
+				regionNames = append(regionNames, *region.Name)
+			}
+		}
+	}
+
+	// The version must be replicated to the region it is created in.
+	if !containsRegion(regionNames, params.Location) {
+		regionNames = append(regionNames, params.Location)
+	}
+
+	for _, name := range regionNames {
+		region := &armcompute.TargetRegion{Name: &name}
+		for _, sourceRegion := range sourceRegions {
+			if sourceRegion != nil && sourceRegion.Name != nil && normalizeRegion(*sourceRegion.Name) == normalizeRegion(name) {
+				region.RegionalReplicaCount = sourceRegion.RegionalReplicaCount
+				region.StorageAccountType = sourceRegion.StorageAccountType
+				region.ExcludeFromLatest = sourceRegion.ExcludeFromLatest
+			}
+		}
+		publishingProfile.TargetRegions = append(publishingProfile.TargetRegions, region)
+	}
+
+	version := armcompute.GalleryImageVersion{
+		Location: &params.Location,
+		Tags:     resourcetags.Merge(source.Tags, params.Tags),
+		Properties: &armcompute.GalleryImageVersionProperties{
+			PublishingProfile: &publishingProfile,
+			StorageProfile: &armcompute.GalleryImageVersionStorageProfile{
+				Source: &armcompute.GalleryArtifactVersionFullSource{ID: source.ID},
+			},
+		},
+	}
+
+	return version, nil
+}
+
+// CreateImageVersion creates the image version and waits until it is
+// replicated to every target region.
+func CreateImageVersion(subscriptionID string, cred azcore.TokenCredential, params CopyParams, version armcompute.GalleryImageVersion) error {
+	clientFactory, err := armcompute.NewClientFactory(subscriptionID, cred, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create client factory: %w", err)
+	}
+	client := clientFactory.NewGalleryImageVersionsClient()
+
+	target := params.Target
+	log.Printf("Creating image version %s in image definition %s", params.VersionName, target.ImageName)
+	poller, err := client.BeginCreateOrUpdate(context.Background(), target.ResourceGroup, target.GalleryName, target.ImageName, params.VersionName, version, nil)
+	if err != nil {
+		return fmt.Errorf("error creating image version %s: %w", params.VersionName, err)
+	}
+
+	pollCtx, cancel := context.WithTimeout(context.Background(), params.Timeout)
+	defer cancel()
+
+	if _, err = poller.PollUntilDone(pollCtx, nil); err != nil {
+		if err == context.DeadlineExceeded {
+			return fmt.Errorf("polling timeout exceeded: %w", err)
+		}
+
+		return fmt.Errorf("error creating image version %s: %w", params.VersionName, err)
+	}
+
+	return WaitForReplication(subscriptionID, cred, target, params.VersionName, params.Timeout)
+}
+
+func containsRegion(regions []string, region string) bool {
+	for _, name := range regions {
+		if normalizeRegion(name) == normalizeRegion(region) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// normalizeRegion lets display names such as "East US" match names such as
+// eastus.
+func normalizeRegion(region string) string {
+	return strings.ToLower(strings.ReplaceAll(region, " ", ""))
+}
@@ -0,0 +1,271 @@
+package imageversion
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+)
+
+const keepTag = "keep"
+
+type ImageParams struct {
+	ResourceGroup string
+	GalleryName   string
+	ImageName     string
+}
+
+type RetentionPolicy struct {
+	KeepLatest      int
+	KeepYoungerThan time.Duration
+	// AllowDeleteAll deletes every version when none of them can be latest,
+	// otherwise the highest version is kept.
+	AllowDeleteAll bool
+}
+
+type PruneDecision struct {
+	Version armcompute.GalleryImageVersion
+	Delete  bool
+	Reason  string
+}
+
+// ListImageVersions returns all versions of the image definition, newest
+// first.
+func ListImageVersions(subscriptionID string, cred azcore.TokenCredential, params ImageParams) ([]armcompute.GalleryImageVersion, error) {
+	clientFactory, err := armcompute.NewClientFactory(subscriptionID, cred, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client factory: %w", err)
+	}
+	client := clientFactory.NewGalleryImageVersionsClient()
+
+	ctx := context.Background()
+	var versions []armcompute.GalleryImageVersion
+	pager := client.NewListByGalleryImagePager(params.ResourceGroup, params.GalleryName, params.ImageName, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving image version page: %w", err)
+		}
+
+		for _, version := range page.Value {
+			versions = append(versions, *version)
+		}
+	}
+
+	slices.SortStableFunc(versions, compareNewestFirst)
+
+	return versions, nil
+}
+
+// GetImageVersion returns the image version including the replication status
+// of each of its regions, which listing versions does not return.
+func GetImageVersion(subscriptionID string, cred azcore.TokenCredential, params ImageParams, versionName string) (armcompute.GalleryImageVersion, error) {
+	clientFactory, err := armcompute.NewClientFactory(subscriptionID, cred, nil)
+	if err != nil {
+		return armcompute.GalleryImageVersion{}, fmt.Errorf("failed to create client factory: %w", err)
+	}
+	client := clientFactory.NewGalleryImageVersionsClient()
+
+	expand := armcompute.ReplicationStatusTypesReplicationStatus
+	options := &armcompute.GalleryImageVersionsClientGetOptions{Expand: &expand}
+	resp, err := client.Get(context.Background(), params.ResourceGroup, params.GalleryName, params.ImageName, versionName, options)
+	if err != nil {
+		return armcompute.GalleryImageVersion{}, fmt.Errorf("error retrieving image version %s: %w", versionName, err)
+	}
+
+	return resp.GalleryImageVersion, nil
+}
+
+func DeleteImageVersion(subscriptionID string, cred azcore.TokenCredential, params ImageParams, versionName string) error {
+	clientFactory, err := armcompute.NewClientFactory(subscriptionID, cred, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create client factory: %w", err)
+	}
+	client := clientFactory.NewGalleryImageVersionsClient()
+
+	poller, err := client.BeginDelete(context.Background(), params.ResourceGroup, params.GalleryName, params.ImageName, versionName, nil)
+	if err != nil {
+		return fmt.Errorf("error deleting image version %s: %w", versionName, err)
+	}
+
+	// Deleting a version removes all of its replicas, which takes longer than
+	// creating the other gallery resources.
+	pollCtx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	if _, err = poller.PollUntilDone(pollCtx, nil); err != nil {
+		return fmt.Errorf("error deleting image version %s: %w", versionName, err)
+	}
+
+	log.Println("Deleted image version:", versionName)
+
+	return nil
+}
+
+// PlanPrune decides which of the versions, sorted newest first, are deleted.
+// A version is kept if it is one of the newest KeepLatest versions, younger
+// than KeepYoungerThan or tagged keep=true. The version latest resolves to is
+// always kept, so the image definition keeps a latest version, and the
+// highest version is kept unless AllowDeleteAll is set.
+func PlanPrune(versions []armcompute.GalleryImageVersion, policy RetentionPolicy, now time.Time) []PruneDecision {
+	decisions := make([]PruneDecision, len(versions))
+	keepsLatest := false
+	published := 0
+	for i, version := range versions {
+		decision := PruneDecision{Version: version, Delete: true, Reason: "outside of the retention policy"}
+		publishedDate, hasPublished := PublishedDate(version)
+
+		switch {
+		case !hasPublished:
+			decision = PruneDecision{Version: version, Reason: "not published yet"}
+		case published < policy.KeepLatest:
+			decision = PruneDecision{Version: version, Reason: fmt.Sprintf("one of the newest %d versions", policy.KeepLatest)}
+		case policy.KeepYoungerThan > 0 && now.Sub(publishedDate) < policy.KeepYoungerThan:
+			decision = PruneDecision{Version: version, Reason: fmt.Sprintf("younger than %s", policy.KeepYoungerThan)}
+		case HasKeepTag(version):
+			decision = PruneDecision{Version: version, Reason: "tagged keep=true"}
+		}
+
+		if hasPublished {
+			published++
+		}
+		if !decision.Delete && eligibleForLatest(version) {
+			keepsLatest = true
+		}
+		decisions[i] = decision
+	}
+
+	if keepsLatest {
+		return decisions
+	}
+
+	// None of the kept versions can be latest, so the version latest
+	// resolves to is one of the versions to delete.
+	if latest, ok := LatestVersion(versions); ok {
+		keepVersion(decisions, latest, "version latest resolves to")
+		return decisions
+	}
+
+	if !policy.AllowDeleteAll {
+		var highest armcompute.GalleryImageVersion
+		keepsAny := false
+		for _, decision := range decisions {
+			if !decision.Delete {
+				keepsAny = true
+				break
+			}
+			if highest.Name == nil || CompareVersionNames(Name(decision.Version), Name(highest)) > 0 {
+				highest = decision.Version
+			}
+		}
+		if !keepsAny && highest.Name != nil {
+			keepVersion(decisions, highest, "last version, no version can be latest")
+		}
+	}
+
+	return decisions
+}
+
+func keepVersion(decisions []PruneDecision, version armcompute.GalleryImageVersion, reason string) {
+	for i, decision := range decisions {
+		if Name(decision.Version) == Name(version) {
+			decisions[i] = PruneDecision{Version: version, Reason: reason}
+		}
+	}
+}
+
+func PrintPrunePlan(w io.Writer, decisions []PruneDecision) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tPUBLISHED\tACTION\tREASON")
+	for _, decision := range decisions {
+		action := "keep"
+		if decision.Delete {
+			action = "delete"
+		}
+
+		published := "-"
+		if date, ok := PublishedDate(decision.Version); ok {
+			published = date.Format(time.RFC3339)
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", Name(decision.Version), published, action, decision.Reason)
+	}
+
+	return tw.Flush()
+}
+
+func Name(version armcompute.GalleryImageVersion) string {
+	if version.Name == nil {
+		return ""
+	}
+
+	return *version.Name
+}
+
+func PublishedDate(version armcompute.GalleryImageVersion) (time.Time, bool) {
+	if version.Properties == nil || version.Properties.PublishingProfile == nil || version.Properties.PublishingProfile.PublishedDate == nil {
+		return time.Time{}, false
+	}
+
+	return *version.Properties.PublishingProfile.PublishedDate, true
+}
+
+func ExcludedFromLatest(version armcompute.GalleryImageVersion) bool {
+	if version.Properties == nil || version.Properties.PublishingProfile == nil || version.Properties.PublishingProfile.ExcludeFromLatest == nil {
+		return false
+	}
+
+	return *version.Properties.PublishingProfile.ExcludeFromLatest
+}
+
+func HasKeepTag(version armcompute.GalleryImageVersion) bool {
+	value, ok := version.Tags[keepTag]
+
+	return ok && value != nil && strings.EqualFold(*value, "true")
+}
+
+func compareNewestFirst(a armcompute.GalleryImageVersion, b armcompute.GalleryImageVersion) int {
+	aDate, aOK := PublishedDate(a)
+	bDate, bOK := PublishedDate(b)
+	switch {
+	case aOK && bOK && !aDate.Equal(bDate):
+		return bDate.Compare(aDate)
+	case aOK != bOK:
+		// Versions still being published are the newest.
+		if aOK {
+			return 1
+		}
+		return -1
+	}
+
+	return CompareVersionNames(Name(b), Name(a))
+}
+
+// CompareVersionNames compares two major.minor.patch version names
+// numerically.
+func CompareVersionNames(a string, b string) int {
+	aParts := strings.Split(a, ".")
+	bParts := strings.Split(b, ".")
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		var aValue, bValue int
+		if i < len(aParts) {
+			aValue, _ = strconv.Atoi(aParts[i])
+		}
+		if i < len(bParts) {
+			bValue, _ = strconv.Atoi(bParts[i])
+		}
+		if aValue != bValue {
+			return aValue - bValue
+		}
+	}
+
+	return 0
+}
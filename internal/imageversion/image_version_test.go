@@ -0,0 +1,207 @@
+package imageversion
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+)
+
+var testNow = time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+type testVersion struct {
+	name string
+	// age is how long before testNow the version was published, zero when it
+	// is not published yet.
+	age       time.Duration
+	excluded  bool
+	state     armcompute.GalleryProvisioningState
+	endOfLife time.Time
+	tags      map[string]string
+}
+
+func newTestVersion(test testVersion) armcompute.GalleryImageVersion {
+	state := test.state
+	if state == "" {
+		state = armcompute.GalleryProvisioningStateSucceeded
+	}
+
+	profile := &armcompute.GalleryImageVersionPublishingProfile{
+		ExcludeFromLatest: &test.excluded,
+	}
+	if test.age != 0 {
+		published := testNow.Add(-test.age)
+		profile.PublishedDate = &published
+	}
+	if !test.endOfLife.IsZero() {
+		profile.EndOfLifeDate = &test.endOfLife
+	}
+
+	version := armcompute.GalleryImageVersion{
+		Name: &test.name,
+		Properties: &armcompute.GalleryImageVersionProperties{
+			ProvisioningState: &state,
+			PublishingProfile: profile,
+		},
+	}
+	if len(test.tags) > 0 {
+		version.Tags = map[string]*string{}
+		for key, value := range test.tags {
+			version.Tags[key] = &value
+		}
+	}
+
+	return version
+}
+
+func newTestVersions(tests ...testVersion) []armcompute.GalleryImageVersion {
+	var versions []armcompute.GalleryImageVersion
+	for _, test := range tests {
+		versions = append(versions, newTestVersion(test))
+	}
+
+	return versions
+}
+
+const day = 24 * time.Hour
+
+func TestPlanPrune(t *testing.T) {
+	tests := []struct {
+		name     string
+		versions []testVersion
+		policy   RetentionPolicy
+		deleted  []string
+	}{
+		{
+			name: "keeps the newest versions",
+			versions: []testVersion{
+				{name: "1.0.3", age: 1 * day},
+				{name: "1.0.2", age: 2 * day},
+				{name: "1.0.1", age: 3 * day},
+			},
+			policy:  RetentionPolicy{KeepLatest: 1},
+			deleted: []string{"1.0.2", "1.0.1"},
+		},
+		{
+			name: "does not count versions which are not published yet",
+			versions: []testVersion{
+				{name: "1.0.4"},
+				{name: "1.0.3", age: 1 * day},
+				{name: "1.0.2", age: 2 * day},
+			},
+			policy:  RetentionPolicy{KeepLatest: 1},
+			deleted: []string{"1.0.2"},
+		},
+		{
+			name: "keeps young versions",
+			versions: []testVersion{
+				{name: "1.0.3", age: 1 * day},
+				{name: "1.0.2", age: 3 * day},
+			},
+			policy:  RetentionPolicy{KeepYoungerThan: 2 * day},
+			deleted: []string{"1.0.2"},
+		},
+		{
+			name: "keeps versions tagged keep",
+			versions: []testVersion{
+				{name: "1.0.3", age: 1 * day},
+				{name: "1.0.2", age: 2 * day, tags: map[string]string{"keep": "True"}},
+				{name: "1.0.1", age: 3 * day},
+			},
+			policy:  RetentionPolicy{KeepLatest: 1},
+			deleted: []string{"1.0.1"},
+		},
+		{
+			name: "keeps the newest version not excluded from latest",
+			versions: []testVersion{
+				{name: "1.0.3", age: 1 * day, excluded: true},
+				{name: "1.0.2", age: 2 * day},
+				{name: "1.0.1", age: 3 * day},
+			},
+			policy:  RetentionPolicy{KeepLatest: 1},
+			deleted: []string{"1.0.1"},
+		},
+		{
+			name: "does not count a failed version as latest",
+			versions: []testVersion{
+				{name: "1.0.3", age: 1 * day, state: armcompute.GalleryProvisioningStateFailed},
+				{name: "1.0.2", age: 2 * day},
+				{name: "1.0.1", age: 3 * day},
+			},
+			policy:  RetentionPolicy{KeepLatest: 1},
+			deleted: []string{"1.0.1"},
+		},
+		{
+			name: "does not keep a failed version for latest",
+			versions: []testVersion{
+				{name: "1.0.3", age: 1 * day, excluded: true},
+				{name: "1.0.2", age: 2 * day, state: armcompute.GalleryProvisioningStateFailed},
+				{name: "1.0.1", age: 3 * day},
+			},
+			policy:  RetentionPolicy{KeepLatest: 1},
+			deleted: []string{"1.0.2"},
+		},
+		{
+			name: "keeps the highest version number for latest",
+			versions: []testVersion{
+				{name: "1.0.9", age: 1 * day},
+				{name: "1.0.10", age: 2 * day},
+			},
+			policy:  RetentionPolicy{},
+			deleted: []string{"1.0.9"},
+		},
+		{
+			name: "keeps the highest version when no version can be latest",
+			versions: []testVersion{
+				{name: "1.0.9", age: 1 * day, excluded: true},
+				{name: "1.0.10", age: 2 * day, excluded: true},
+			},
+			policy:  RetentionPolicy{},
+			deleted: []string{"1.0.9"},
+		},
+		{
+			name: "deletes everything when no version can be latest and allowed",
+			versions: []testVersion{
+				{name: "1.0.2", age: 2 * day, excluded: true},
+				{name: "1.0.1", age: 3 * day, excluded: true},
+			},
+			policy:  RetentionPolicy{AllowDeleteAll: true},
+			deleted: []string{"1.0.2", "1.0.1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var deleted []string
+			for _, decision := range PlanPrune(newTestVersions(test.versions...), test.policy, testNow) {
+				if decision.Delete {
+					deleted = append(deleted, Name(decision.Version))
+				}
+			}
+
+			if !slices.Equal(deleted, test.deleted) {
+				t.Errorf("deleted %v, want %v", deleted, test.deleted)
+			}
+		})
+	}
+}
+
+func TestCompareVersionNames(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{a: "1.0.10", b: "1.0.9", want: 1},
+		{a: "1.0.9", b: "1.0.10", want: -1},
+		{a: "2025.105.930", b: "2025.105.930", want: 0},
+		{a: "2.0.0", b: "1.99.99", want: 1},
+	}
+
+	for _, test := range tests {
+		got := CompareVersionNames(test.a, test.b)
+		if (got > 0) != (test.want > 0) || (got < 0) != (test.want < 0) {
+			t.Errorf("CompareVersionNames(%q, %q) = %d, want sign of %d", test.a, test.b, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package imageversion
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/urfave/cli/v2"
+)
+
+// VersionUpdate holds the publishing settings to change on an image
+// version, nil fields are left unchanged. Tags are added to the existing tags.
+type VersionUpdate struct {
+	ExcludeFromLatest *bool
+	EndOfLifeDate     *time.Time
+	Tags              map[string]*string
+}
+
+// PublishingParams identifies the image version whose publishing settings
+// promote_image_version and deprecate_image_version change.
+type PublishingParams struct {
+	SubscriptionID string
+	Image          ImageParams
+	VersionName    string
+	// EndOfLifeDate is nil when --endOfLifeDate is not given.
+	EndOfLifeDate *time.Time
+}
+
+// PublishingFlags returns the flags shared by the commands which change the
+// publishing settings of an image version.
+func PublishingFlags(versionUsage string, endOfLifeUsage string) []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "subscriptionID",
+			Aliases: []string{"s"},
+			Usage:   "Azure subscription ID",
+			EnvVars: []string{"AZURE_SUBSCRIPTION_ID"},
+		},
+		&cli.StringFlag{
+			Name:     "resourceGroup",
+			Aliases:  []string{"g"},
+			Usage:    "Azure resource group name",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:     "galleryName",
+			Usage:    "The name of the image gallery",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "imageName",
+			Usage: "The name of the image definition",
+			Value: "aibDemoImage",
+		},
+		&cli.StringFlag{
+			Name:     "version",
+			Usage:    versionUsage,
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "endOfLifeDate",
+			Usage: endOfLifeUsage,
+		},
+	}
+}
+
+func PublishingParamsFromContext(c *cli.Context) (PublishingParams, error) {
+	params := PublishingParams{
+		SubscriptionID: c.String("subscriptionID"),
+		Image: ImageParams{
+			ResourceGroup: c.String("resourceGroup"),
+			GalleryName:   c.String("galleryName"),
+			ImageName:     c.String("imageName"),
+		},
+		VersionName: c.String("version"),
+	}
+
+	if value := c.String("endOfLifeDate"); value != "" {
+		endOfLifeDate, err := ParseEndOfLifeDate(value)
+		if err != nil {
+			return params, err
+		}
+		params.EndOfLifeDate = &endOfLifeDate
+	}
+
+	return params, nil
+}
+
+// PromoteImageVersion includes the version in latest. A version past its end
+// of life stays past it when promoted, so a new end of life date in the
+// future is required for it.
+func PromoteImageVersion(cred azcore.TokenCredential, params PublishingParams, now time.Time) error {
+	excludeFromLatest := false
+	update := VersionUpdate{ExcludeFromLatest: &excludeFromLatest}
+
+	if params.EndOfLifeDate != nil {
+		if params.EndOfLifeDate.Before(now) {
+			return fmt.Errorf("end of life date of a promoted version must be in the future: %s", params.EndOfLifeDate.Format(time.DateOnly))
+		}
+		update.EndOfLifeDate = params.EndOfLifeDate
+	} else {
+		version, err := GetImageVersion(params.SubscriptionID, cred, params.Image, params.VersionName)
+		if err != nil {
+			return err
+		}
+		if endOfLife, ok := EndOfLifeDate(version); ok && endOfLife.Before(now) {
+			return fmt.Errorf("image version %s reached its end of life on %s, set a new end of life date to promote it", params.VersionName, endOfLife.Format(time.DateOnly))
+		}
+	}
+
+	return UpdateImageVersion(params.SubscriptionID, cred, params.Image, params.VersionName, update)
+}
+
+// DeprecateImageVersion excludes the version from latest and sets its end of
+// life date, today unless a date is given.
+func DeprecateImageVersion(cred azcore.TokenCredential, params PublishingParams, now time.Time) error {
+	endOfLifeDate := now.UTC().Truncate(24 * time.Hour)
+	if params.EndOfLifeDate != nil {
+		endOfLifeDate = *params.EndOfLifeDate
+	}
+
+	excludeFromLatest := true
+	update := VersionUpdate{
+		ExcludeFromLatest: &excludeFromLatest,
+		EndOfLifeDate:     &endOfLifeDate,
+	}
+
+	return UpdateImageVersion(params.SubscriptionID, cred, params.Image, params.VersionName, update)
+}
+
+func ParseEndOfLifeDate(value string) (time.Time, error) {
+	date, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("end of life date must be in the format YYYY-MM-DD, got: %s", value)
+	}
+
+	return date, nil
+}
+
+// ParseVersionID returns the image definition and version name of an image
+// version ID.
+func ParseVersionID(versionID string) (string, ImageParams, string, error) {
+	resourceID, err := arm.ParseResourceID(versionID)
+	if err != nil {
+		return "", ImageParams{}, "", fmt.Errorf("invalid image version ID %s: %w", versionID, err)
+	}
+
+	if !strings.EqualFold(resourceID.ResourceType.String(), "Microsoft.Compute/galleries/images/versions") {
+		return "", ImageParams{}, "", fmt.Errorf("not an image version ID: %s", versionID)
+	}
+
+	params := ImageParams{
+		ResourceGroup: resourceID.ResourceGroupName,
+		GalleryName:   resourceID.Parent.Parent.Name,
+		ImageName:     resourceID.Parent.Name,
+	}
+
+	return resourceID.SubscriptionID, params, resourceID.Name, nil
+}
+
+func UpdateImageVersion(subscriptionID string, cred azcore.TokenCredential, params ImageParams, versionName string, update VersionUpdate) error {
+	clientFactory, err := armcompute.NewClientFactory(subscriptionID, cred, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create client factory: %w", err)
+	}
+	client := clientFactory.NewGalleryImageVersionsClient()
+
+	ctx := context.Background()
+	resp, err := client.Get(ctx, params.ResourceGroup, params.GalleryName, params.ImageName, versionName, nil)
+	if err != nil {
+		return fmt.Errorf("error retrieving image version %s: %w", versionName, err)
+	}
+
+	properties := resp.Properties
+	if properties == nil {
+		return fmt.Errorf("image version %s has no properties", versionName)
+	}
+
+	// The version is updated with its full properties as the storage profile
+	// is required, without the fields set by Azure.
+	properties.ProvisioningState = nil
+	properties.ReplicationStatus = nil
+	if properties.PublishingProfile == nil {
+		properties.PublishingProfile = &armcompute.GalleryImageVersionPublishingProfile{}
+	}
+	properties.PublishingProfile.PublishedDate = nil
+
+	if update.ExcludeFromLatest != nil {
+		properties.PublishingProfile.ExcludeFromLatest = update.ExcludeFromLatest
+	}
+	if update.EndOfLifeDate != nil {
+		properties.PublishingProfile.EndOfLifeDate = update.EndOfLifeDate
+	}
+
+	tags := resp.Tags
+	if len(update.Tags) > 0 && tags == nil {
+		tags = map[string]*string{}
+	}
+	for key, value := range update.Tags {
+		tags[key] = value
+	}
+
+	versionUpdate := armcompute.GalleryImageVersionUpdate{
+		Properties: properties,
+		Tags:       tags,
+	}
+	poller, err := client.BeginUpdate(ctx, params.ResourceGroup, params.GalleryName, params.ImageName, versionName, versionUpdate, nil)
+	if err != nil {
+		return fmt.Errorf("error updating image version %s: %w", versionName, err)
+	}
+
+	pollCtx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	if _, err = poller.PollUntilDone(pollCtx, nil); err != nil {
+		return fmt.Errorf("error updating image version %s: %w", versionName, err)
+	}
+
+	log.Println("Updated image version:", versionName)
+
+	return nil
+}
@@ -0,0 +1,162 @@
+package imageversion
+
+import (
+	"aib-pipeline-demo/internal/deref"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+)
+
+// RegionSettings holds the replication settings which the shared image
+// distributor of Azure Image Builder cannot express, and which can only be
+// set when creating an image version through the gallery API.
+type RegionSettings struct {
+	// ReplicationMode is empty for the gallery default, Full.
+	ReplicationMode armcompute.ReplicationMode
+	// OSDiskEncryptionSetIDs maps normalized region names to the disk
+	// encryption set used to encrypt the OS disk image in that region.
+	OSDiskEncryptionSetIDs map[string]string
+	// CMKRequiredRegions are the normalized names of the regions in which the
+	// image must be encrypted with a customer-managed key.
+	CMKRequiredRegions []string
+}
+
+// ParseRegionSettings reads the replication mode and the encryption sets,
+// given as region=diskEncryptionSetID.
+func ParseRegionSettings(replicationMode string, encryptionSets []string, cmkRequiredRegions []string) (RegionSettings, error) {
+	settings := RegionSettings{OSDiskEncryptionSetIDs: map[string]string{}}
+
+	if replicationMode != "" {
+		found := false
+		for _, mode := range armcompute.PossibleReplicationModeValues() {
+			if strings.EqualFold(replicationMode, string(mode)) {
+				settings.ReplicationMode = mode
+				found = true
+			}
+		}
+		if !found {
+			return settings, fmt.Errorf("unknown replication mode, expected Full or Shallow: %s", replicationMode)
+		}
+	}
+
+	for _, value := range encryptionSets {
+		region, encryptionSetID, found := strings.Cut(value, "=")
+		if !found || region == "" || encryptionSetID == "" {
+			return settings, fmt.Errorf("disk encryption set must be in the format region=diskEncryptionSetID, got: %s", value)
+		}
+
+		resourceID, err := arm.ParseResourceID(encryptionSetID)
+		if err != nil || !strings.EqualFold(resourceID.ResourceType.String(), "Microsoft.Compute/diskEncryptionSets") {
+			return settings, fmt.Errorf("not a disk encryption set ID: %s", encryptionSetID)
+		}
+
+		if _, ok := settings.OSDiskEncryptionSetIDs[normalizeRegion(region)]; ok {
+			return settings, fmt.Errorf("more than one disk encryption set for region %s", region)
+		}
+		settings.OSDiskEncryptionSetIDs[normalizeRegion(region)] = encryptionSetID
+	}
+
+	for _, region := range cmkRequiredRegions {
+		settings.CMKRequiredRegions = append(settings.CMKRequiredRegions, normalizeRegion(region))
+	}
+
+	return settings, nil
+}
+
+// Validate checks that every target region which requires a customer-managed
+// key has a disk encryption set, and that every disk encryption set is for a
+// target region.
+func (settings RegionSettings) Validate(targetRegions []string) error {
+	var missing []string
+	for _, region := range targetRegions {
+		normalized := normalizeRegion(region)
+		if _, ok := settings.OSDiskEncryptionSetIDs[normalized]; !ok && slices.Contains(settings.CMKRequiredRegions, normalized) {
+			missing = append(missing, region)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("regions %s require a customer-managed key, but have no disk encryption set", strings.Join(missing, ", "))
+	}
+
+	for region := range settings.OSDiskEncryptionSetIDs {
+		if !containsRegion(targetRegions, region) {
+			return fmt.Errorf("disk encryption set given for %s, which is not a target region", region)
+		}
+	}
+
+	return nil
+}
+
+// ValidateDistributorRegions fails if a target region of the Azure Image
+// Builder distributor requires a customer-managed key, which the distributor
+// cannot set.
+func ValidateDistributorRegions(targetRegions []string, cmkRequiredRegions []string) error {
+	var required []string
+	for _, region := range targetRegions {
+		if containsRegion(cmkRequiredRegions, region) {
+			required = append(required, region)
+		}
+	}
+	if len(required) > 0 {
+		return fmt.Errorf("regions %s require a customer-managed key, which the Azure Image Builder distributor cannot set, remove them from the target regions and use copy_image_version to replicate to them", strings.Join(required, ", "))
+	}
+
+	return nil
+}
+
+// Apply sets the replication mode and the encryption of each target region
+// on the image version.
+func (settings RegionSettings) Apply(version *armcompute.GalleryImageVersion) {
+	if version.Properties == nil || version.Properties.PublishingProfile == nil {
+		return
+	}
+
+	profile := version.Properties.PublishingProfile
+	if settings.ReplicationMode != "" {
+		profile.ReplicationMode = &settings.ReplicationMode
+	}
+
+	for _, region := range profile.TargetRegions {
+		if region == nil || region.Name == nil {
+			continue
+		}
+		if encryptionSetID, ok := settings.OSDiskEncryptionSetIDs[normalizeRegion(*region.Name)]; ok {
+			region.Encryption = &armcompute.EncryptionImages{
+				OSDiskImage: &armcompute.OSDiskImageEncryption{DiskEncryptionSetID: &encryptionSetID},
+			}
+		}
+	}
+}
+
+// CheckEncryptionSetLocations fails if a disk encryption set is not in the
+// region it encrypts the image in, which Azure requires.
+func (settings RegionSettings) CheckEncryptionSetLocations(cred azcore.TokenCredential) error {
+	for region, encryptionSetID := range settings.OSDiskEncryptionSetIDs {
+		resourceID, err := arm.ParseResourceID(encryptionSetID)
+		if err != nil {
+			return fmt.Errorf("invalid disk encryption set ID %s: %w", encryptionSetID, err)
+		}
+
+		clientFactory, err := armcompute.NewClientFactory(resourceID.SubscriptionID, cred, nil)
+		if err != nil {
+			return fmt.Errorf("failed to create client factory: %w", err)
+		}
+		client := clientFactory.NewDiskEncryptionSetsClient()
+
+		resp, err := client.Get(context.Background(), resourceID.ResourceGroupName, resourceID.Name, nil)
+		if err != nil {
+			return fmt.Errorf("error retrieving disk encryption set %s: %w", encryptionSetID, err)
+		}
+
+		if resp.Location == nil || normalizeRegion(*resp.Location) != region {
+			return fmt.Errorf("disk encryption set %s is in %s, not in %s", resourceID.Name, deref.String(resp.Location), region)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package imageversion
+
+import (
+	"aib-pipeline-demo/internal/deref"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+)
+
+const replicationPollInterval = 30 * time.Second
+
+// WaitForReplication polls the image version until it is replicated to every
+// target region, logging each region's progress as it changes. It fails as
+// soon as replication to a region fails or the timeout is exceeded.
+func WaitForReplication(subscriptionID string, cred azcore.TokenCredential, params ImageParams, versionName string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	reported := map[string]string{}
+	for {
+		version, err := GetImageVersion(subscriptionID, cred, params, versionName)
+		if err != nil {
+			return err
+		}
+
+		summaries := replicationSummaries(version)
+		completed := len(summaries) > 0
+		var failed []string
+		for _, summary := range summaries {
+			region := deref.String(summary.Region)
+			state := deref.String(summary.State)
+			progress := int32(0)
+			if summary.Progress != nil {
+				progress = *summary.Progress
+			}
+
+			status := fmt.Sprintf("%s %d%%", state, progress)
+			if reported[region] != status {
+				log.Printf("Replication of %s to %s: %s", versionName, region, status)
+				reported[region] = status
+			}
+
+			switch armcompute.ReplicationState(state) {
+			case armcompute.ReplicationStateCompleted:
+			case armcompute.ReplicationStateFailed:
+				failed = append(failed, fmt.Sprintf("%s: %s", region, deref.String(summary.Details)))
+				completed = false
+			default:
+				completed = false
+			}
+		}
+
+		if len(failed) > 0 {
+			return fmt.Errorf("replication of image version %s failed in %s", versionName, strings.Join(failed, "; "))
+		}
+		if completed {
+			log.Println("Replicated image version:", versionName)
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("image version %s was not replicated within %s", versionName, timeout)
+		}
+
+		time.Sleep(replicationPollInterval)
+	}
+}
+
+func replicationSummaries(version armcompute.GalleryImageVersion) []*armcompute.RegionalReplicationStatus {
+	if version.Properties == nil || version.Properties.ReplicationStatus == nil {
+		return nil
+	}
+
+	var summaries []*armcompute.RegionalReplicationStatus
+	for _, summary := range version.Properties.ReplicationStatus.Summary {
+		if summary != nil {
+			summaries = append(summaries, summary)
+		}
+	}
+
+	return summaries
+}
@@ -0,0 +1,126 @@
+package imageversion
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+)
+
+const rolledBackTag = "rolledBack"
+
+type RollbackPlan struct {
+	RolledBack armcompute.GalleryImageVersion
+	Previous   armcompute.GalleryImageVersion
+}
+
+// LatestVersion returns the version latest resolves to, which is the highest
+// version number not excluded from latest.
+func LatestVersion(versions []armcompute.GalleryImageVersion) (armcompute.GalleryImageVersion, bool) {
+	var latest armcompute.GalleryImageVersion
+	found := false
+	for _, version := range versions {
+		if !eligibleForLatest(version) {
+			continue
+		}
+		if !found || CompareVersionNames(Name(version), Name(latest)) > 0 {
+			latest = version
+			found = true
+		}
+	}
+
+	return latest, found
+}
+
+// PlanRollback finds the version to roll back, the current latest version if
+// versionName is empty, and the previous good version which becomes latest:
+// the highest lower version which is not rolled back or past its end of life.
+// Versions excluded from latest, for example after a deprecation, are only
+// chosen when no other version qualifies, and never when skipExcluded is set.
+func PlanRollback(versions []armcompute.GalleryImageVersion, versionName string, skipExcluded bool, now time.Time) (RollbackPlan, error) {
+	var plan RollbackPlan
+	if versionName == "" {
+		latest, ok := LatestVersion(versions)
+		if !ok {
+			return plan, fmt.Errorf("no version is eligible for latest")
+		}
+		plan.RolledBack = latest
+	} else {
+		found := false
+		for _, version := range versions {
+			if Name(version) == versionName {
+				plan.RolledBack = version
+				found = true
+			}
+		}
+		if !found {
+			return plan, fmt.Errorf("image version %s not found", versionName)
+		}
+	}
+
+	var previous, previousExcluded armcompute.GalleryImageVersion
+	found, foundExcluded := false, false
+	for _, version := range versions {
+		if CompareVersionNames(Name(version), Name(plan.RolledBack)) >= 0 || IsRolledBack(version) || !succeeded(version) {
+			continue
+		}
+		if endOfLife, ok := EndOfLifeDate(version); ok && endOfLife.Before(now) {
+			continue
+		}
+		if ExcludedFromLatest(version) {
+			if !foundExcluded || CompareVersionNames(Name(version), Name(previousExcluded)) > 0 {
+				previousExcluded = version
+				foundExcluded = true
+			}
+			continue
+		}
+		if !found || CompareVersionNames(Name(version), Name(previous)) > 0 {
+			previous = version
+			found = true
+		}
+	}
+
+	switch {
+	case found:
+		plan.Previous = previous
+	case foundExcluded && !skipExcluded:
+		plan.Previous = previousExcluded
+	case foundExcluded:
+		return plan, fmt.Errorf("the only good version lower than %s is %s, which is excluded from latest and skipped", Name(plan.RolledBack), Name(previousExcluded))
+	default:
+		return plan, fmt.Errorf("no good version lower than %s to roll back to", Name(plan.RolledBack))
+	}
+
+	return plan, nil
+}
+
+func IsRolledBack(version armcompute.GalleryImageVersion) bool {
+	value, ok := version.Tags[rolledBackTag]
+
+	return ok && value != nil && strings.EqualFold(*value, "true")
+}
+
+// RolledBackTags returns the tags marking a version as rolled back.
+func RolledBackTags() map[string]*string {
+	value := "true"
+
+	return map[string]*string{rolledBackTag: &value}
+}
+
+func EndOfLifeDate(version armcompute.GalleryImageVersion) (time.Time, bool) {
+	if version.Properties == nil || version.Properties.PublishingProfile == nil || version.Properties.PublishingProfile.EndOfLifeDate == nil {
+		return time.Time{}, false
+	}
+
+	return *version.Properties.PublishingProfile.EndOfLifeDate, true
+}
+
+// eligibleForLatest reports whether latest can resolve to the version.
+func eligibleForLatest(version armcompute.GalleryImageVersion) bool {
+	return !ExcludedFromLatest(version) && succeeded(version)
+}
+
+func succeeded(version armcompute.GalleryImageVersion) bool {
+	return version.Properties != nil && version.Properties.ProvisioningState != nil && *version.Properties.ProvisioningState == armcompute.GalleryProvisioningStateSucceeded
+}
@@ -0,0 +1,163 @@
+package imageversion
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+)
+
+func TestLatestVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		versions []testVersion
+		want     string
+	}{
+		{
+			name: "highest version number",
+			versions: []testVersion{
+				{name: "1.0.9", age: 1 * day},
+				{name: "1.0.10", age: 2 * day},
+			},
+			want: "1.0.10",
+		},
+		{
+			name: "skips versions excluded from latest",
+			versions: []testVersion{
+				{name: "1.0.2", age: 1 * day, excluded: true},
+				{name: "1.0.1", age: 2 * day},
+			},
+			want: "1.0.1",
+		},
+		{
+			name: "skips versions which did not succeed",
+			versions: []testVersion{
+				{name: "1.0.2", age: 1 * day, state: armcompute.GalleryProvisioningStateFailed},
+				{name: "1.0.1", age: 2 * day},
+			},
+			want: "1.0.1",
+		},
+		{
+			name: "no eligible version",
+			versions: []testVersion{
+				{name: "1.0.1", age: 1 * day, excluded: true},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			latest, found := LatestVersion(newTestVersions(test.versions...))
+			if found != (test.want != "") || Name(latest) != test.want {
+				t.Errorf("LatestVersion() = %q, %t, want %q", Name(latest), found, test.want)
+			}
+		})
+	}
+}
+
+func TestPlanRollback(t *testing.T) {
+	tests := []struct {
+		name         string
+		versions     []testVersion
+		versionName  string
+		skipExcluded bool
+		rolledBack   string
+		previous     string
+		wantErr      bool
+	}{
+		{
+			name: "rolls back latest",
+			versions: []testVersion{
+				{name: "1.0.3", age: 1 * day},
+				{name: "1.0.2", age: 2 * day},
+				{name: "1.0.1", age: 3 * day},
+			},
+			rolledBack: "1.0.3",
+			previous:   "1.0.2",
+		},
+		{
+			name: "rolls back the given version",
+			versions: []testVersion{
+				{name: "1.0.3", age: 1 * day},
+				{name: "1.0.2", age: 2 * day},
+				{name: "1.0.1", age: 3 * day},
+			},
+			versionName: "1.0.2",
+			rolledBack:  "1.0.2",
+			previous:    "1.0.1",
+		},
+		{
+			name: "skips rolled back, failed and end of life versions",
+			versions: []testVersion{
+				{name: "1.0.5", age: 1 * day},
+				{name: "1.0.4", age: 2 * day, tags: map[string]string{"rolledBack": "true"}},
+				{name: "1.0.3", age: 3 * day, state: armcompute.GalleryProvisioningStateFailed},
+				{name: "1.0.2", age: 4 * day, endOfLife: testNow.Add(-day)},
+				{name: "1.0.1", age: 5 * day},
+			},
+			rolledBack: "1.0.5",
+			previous:   "1.0.1",
+		},
+		{
+			name: "prefers versions not excluded from latest",
+			versions: []testVersion{
+				{name: "1.0.3", age: 1 * day},
+				{name: "1.0.2", age: 2 * day, excluded: true},
+				{name: "1.0.1", age: 3 * day},
+			},
+			rolledBack: "1.0.3",
+			previous:   "1.0.1",
+		},
+		{
+			name: "rolls back to an excluded version by default",
+			versions: []testVersion{
+				{name: "1.0.3", age: 1 * day},
+				{name: "1.0.2", age: 2 * day, excluded: true},
+			},
+			rolledBack: "1.0.3",
+			previous:   "1.0.2",
+		},
+		{
+			name: "does not roll back to an excluded version when skipped",
+			versions: []testVersion{
+				{name: "1.0.3", age: 1 * day},
+				{name: "1.0.2", age: 2 * day, excluded: true},
+			},
+			skipExcluded: true,
+			wantErr:      true,
+		},
+		{
+			name: "unknown version",
+			versions: []testVersion{
+				{name: "1.0.1", age: 1 * day},
+			},
+			versionName: "1.0.2",
+			wantErr:     true,
+		},
+		{
+			name: "no previous version",
+			versions: []testVersion{
+				{name: "1.0.1", age: 1 * day},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			plan, err := PlanRollback(newTestVersions(test.versions...), test.versionName, test.skipExcluded, testNow)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("PlanRollback() rolls back %s to %s, want an error", Name(plan.RolledBack), Name(plan.Previous))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("PlanRollback() error: %v", err)
+			}
+
+			if Name(plan.RolledBack) != test.rolledBack || Name(plan.Previous) != test.previous {
+				t.Errorf("PlanRollback() rolls back %s to %s, want %s to %s", Name(plan.RolledBack), Name(plan.Previous), test.rolledBack, test.previous)
+			}
+		})
+	}
+}
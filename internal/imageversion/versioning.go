@@ -0,0 +1,145 @@
+package imageversion
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+)
+
+type Scheme string
+
+const (
+	SchemeAuto     Scheme = "auto"
+	SchemeExplicit Scheme = "explicit"
+	SchemeDate     Scheme = "date"
+	SchemeGit      Scheme = "git"
+)
+
+var versionPattern = regexp.MustCompile(`^(\d+)\.(\d+)\.(\d+)$`)
+
+func ParseScheme(value string) (Scheme, error) {
+	for _, scheme := range []Scheme{SchemeAuto, SchemeExplicit, SchemeDate, SchemeGit} {
+		if strings.EqualFold(value, string(scheme)) {
+			return scheme, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown versioning scheme, expected auto, explicit, date or git: %s", value)
+}
+
+// ResolveVersion returns the gallery image version for the scheme, or an
+// empty version for the auto scheme in which Azure Image Builder numbers the
+// version itself.
+func ResolveVersion(scheme Scheme, explicitVersion string, now time.Time) (string, error) {
+	if explicitVersion != "" && scheme != SchemeExplicit {
+		return "", fmt.Errorf("a version can only be set with the explicit versioning scheme")
+	}
+
+	switch scheme {
+	case SchemeAuto:
+		return "", nil
+	case SchemeExplicit:
+		return explicitVersion, ValidateVersion(explicitVersion)
+	case SchemeDate:
+		return DateVersion(now), nil
+	case SchemeGit:
+		return GitTagVersion()
+	}
+
+	return "", fmt.Errorf("unknown versioning scheme: %s", scheme)
+}
+
+// ValidateVersion checks that the version is a major.minor.patch version of
+// 32-bit integers, the only format galleries accept.
+func ValidateVersion(version string) error {
+	matches := versionPattern.FindStringSubmatch(version)
+	if matches == nil {
+		return fmt.Errorf("version must be in the format major.minor.patch, got: %q", version)
+	}
+
+	for _, part := range matches[1:] {
+		if value, err := strconv.ParseInt(part, 10, 64); err != nil || value > math.MaxInt32 {
+			return fmt.Errorf("version parts must be 32-bit integers, got: %s", version)
+		}
+	}
+
+	return nil
+}
+
+// DateVersion returns the version YYYY.MMDD.HHmm for the time in UTC. The
+// parts are integers, so leading zeros are dropped, for example 2025.105.930
+// for 5 January 2025 at 09:30.
+func DateVersion(now time.Time) string {
+	now = now.UTC()
+
+	return fmt.Sprintf("%d.%d.%d", now.Year(), int(now.Month())*100+now.Day(), now.Hour()*100+now.Minute())
+}
+
+// GitTagVersion returns the version from the git tag pointing at HEAD in the
+// current directory. The tag may have a leading v, such as v1.2.3.
+func GitTagVersion() (string, error) {
+	output, err := exec.Command("git", "describe", "--tags", "--exact-match", "HEAD").Output()
+	if err != nil {
+		return "", fmt.Errorf("error reading the git tag of HEAD, is the commit tagged?: %w", err)
+	}
+
+	tag := strings.TrimSpace(string(output))
+	version := strings.TrimPrefix(tag, "v")
+	if err = ValidateVersion(version); err != nil {
+		return "", fmt.Errorf("git tag %s is not a version: %w", tag, err)
+	}
+
+	return version, nil
+}
+
+// EnsureVersionDoesNotExist fails if the gallery image version already
+// exists, as the build would only fail during distribution.
+func EnsureVersionDoesNotExist(cred azcore.TokenCredential, versionID string) error {
+	subscriptionID, params, versionName, err := ParseVersionID(versionID)
+	if err != nil {
+		return err
+	}
+
+	clientFactory, err := armcompute.NewClientFactory(subscriptionID, cred, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create client factory: %w", err)
+	}
+	client := clientFactory.NewGalleryImageVersionsClient()
+
+	_, err = client.Get(context.Background(), params.ResourceGroup, params.GalleryName, params.ImageName, versionName, nil)
+	if err != nil {
+		switch e := err.(type) {
+		case *azcore.ResponseError:
+			if e.StatusCode == 404 {
+				return nil
+			}
+			return fmt.Errorf("error while retrieving image version: %w", e)
+		default:
+			return fmt.Errorf("error while retrieving image version: %w", e)
+		}
+	}
+
+	return fmt.Errorf("image version %s already exists in image definition %s", versionName, params.ImageName)
+}
+
+// VersionIDs returns the IDs of the gallery images which are image versions.
+func VersionIDs(galleryImageIDs []string) []string {
+	var versionIDs []string
+	for _, id := range galleryImageIDs {
+		resourceID, err := arm.ParseResourceID(id)
+		if err == nil && strings.EqualFold(resourceID.ResourceType.String(), "Microsoft.Compute/galleries/images/versions") {
+			versionIDs = append(versionIDs, id)
+		}
+	}
+
+	return versionIDs
+}
@@ -0,0 +1,252 @@
+package inventory
+
+import (
+	"aib-pipeline-demo/internal/deref"
+	"aib-pipeline-demo/internal/imagedefinition"
+	"aib-pipeline-demo/internal/imagegallery"
+	"aib-pipeline-demo/internal/imageversion"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+)
+
+type Gallery struct {
+	Name          string  `json:"name"`
+	ResourceGroup string  `json:"resourceGroup"`
+	Location      string  `json:"location"`
+	Images        []Image `json:"images"`
+}
+
+type Image struct {
+	Name     string    `json:"name"`
+	Versions []Version `json:"versions"`
+}
+
+type Version struct {
+	Name              string            `json:"name"`
+	ProvisioningState string            `json:"provisioningState"`
+	PublishedDate     *time.Time        `json:"publishedDate,omitempty"`
+	ExcludeFromLatest bool              `json:"excludeFromLatest"`
+	EndOfLifeDate     *time.Time        `json:"endOfLifeDate,omitempty"`
+	Regions           []Region          `json:"regions"`
+	Tags              map[string]string `json:"tags,omitempty"`
+}
+
+type Region struct {
+	Name     string `json:"name"`
+	State    string `json:"state"`
+	Progress int32  `json:"progress"`
+	Details  string `json:"details,omitempty"`
+}
+
+type Filter struct {
+	ResourceGroup string
+	GalleryName   string
+	ImageName     string
+}
+
+// Collect walks the galleries matching the filter, their image definitions and
+// the versions of each definition. Versions are newest first.
+func Collect(subscriptionID string, cred azcore.TokenCredential, filter Filter) ([]Gallery, error) {
+	galleries, err := imagegallery.ListImageGalleries(subscriptionID, cred, filter.ResourceGroup)
+	if err != nil {
+		return nil, fmt.Errorf("error listing galleries: %w", err)
+	}
+
+	var result []Gallery
+	for _, gallery := range galleries {
+		if gallery.ID == nil || gallery.Name == nil {
+			continue
+		}
+		if filter.GalleryName != "" && !strings.EqualFold(*gallery.Name, filter.GalleryName) {
+			continue
+		}
+
+		resourceID, err := arm.ParseResourceID(*gallery.ID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gallery ID %s: %w", *gallery.ID, err)
+		}
+
+		entry := Gallery{
+			Name:          *gallery.Name,
+			ResourceGroup: resourceID.ResourceGroupName,
+			Location:      deref.String(gallery.Location),
+		}
+
+		definitions, err := imagedefinition.ListImageDefinitions(subscriptionID, cred, entry.ResourceGroup, entry.Name)
+		if err != nil {
+			return nil, fmt.Errorf("error listing image definitions of gallery %s: %w", entry.Name, err)
+		}
+
+		for _, definition := range definitions {
+			if definition.Name == nil {
+				continue
+			}
+			if filter.ImageName != "" && !strings.EqualFold(*definition.Name, filter.ImageName) {
+				continue
+			}
+
+			image, err := collectImage(subscriptionID, cred, imageversion.ImageParams{
+				ResourceGroup: entry.ResourceGroup,
+				GalleryName:   entry.Name,
+				ImageName:     *definition.Name,
+			})
+			if err != nil {
+				return nil, err
+			}
+			entry.Images = append(entry.Images, image)
+		}
+
+		result = append(result, entry)
+	}
+
+	return result, nil
+}
+
+func collectImage(subscriptionID string, cred azcore.TokenCredential, params imageversion.ImageParams) (Image, error) {
+	image := Image{Name: params.ImageName}
+
+	versions, err := imageversion.ListImageVersions(subscriptionID, cred, params)
+	if err != nil {
+		return image, fmt.Errorf("error listing versions of image %s: %w", params.ImageName, err)
+	}
+
+	for _, listed := range versions {
+		// Listing versions does not return the replication status.
+		version, err := imageversion.GetImageVersion(subscriptionID, cred, params, imageversion.Name(listed))
+		if err != nil {
+			return image, err
+		}
+		image.Versions = append(image.Versions, newVersion(version))
+	}
+
+	return image, nil
+}
+
+func newVersion(version armcompute.GalleryImageVersion) Version {
+	entry := Version{
+		Name:              imageversion.Name(version),
+		ExcludeFromLatest: imageversion.ExcludedFromLatest(version),
+	}
+	if publishedDate, ok := imageversion.PublishedDate(version); ok {
+		entry.PublishedDate = &publishedDate
+	}
+	if endOfLife, ok := imageversion.EndOfLifeDate(version); ok {
+		entry.EndOfLifeDate = &endOfLife
+	}
+
+	if version.Properties != nil {
+		entry.ProvisioningState = deref.String(version.Properties.ProvisioningState)
+		if version.Properties.ReplicationStatus != nil {
+			for _, summary := range version.Properties.ReplicationStatus.Summary {
+				if summary == nil {
+					continue
+				}
+				region := Region{
+					Name:    deref.String(summary.Region),
+					State:   deref.String(summary.State),
+					Details: deref.String(summary.Details),
+				}
+				if summary.Progress != nil {
+					region.Progress = *summary.Progress
+				}
+				entry.Regions = append(entry.Regions, region)
+			}
+		}
+	}
+
+	if len(version.Tags) > 0 {
+		entry.Tags = map[string]string{}
+		for key, value := range version.Tags {
+			entry.Tags[key] = deref.String(value)
+		}
+	}
+
+	return entry
+}
+
+func WriteJSON(w io.Writer, galleries []Gallery) error {
+	if galleries == nil {
+		galleries = []Gallery{}
+	}
+
+	jsonData, err := json.MarshalIndent(galleries, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error marshaling data: %w", err)
+	}
+
+	_, err = w.Write(append(jsonData, '\n'))
+
+	return err
+}
+
+func PrintTable(w io.Writer, galleries []Gallery) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "GALLERY\tIMAGE\tVERSION\tSTATE\tPUBLISHED\tEXCLUDED\tEND OF LIFE\tREPLICATION\tTAGS")
+	for _, gallery := range galleries {
+		for _, image := range gallery.Images {
+			for _, version := range image.Versions {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%t\t%s\t%s\t%s\n",
+					gallery.Name,
+					image.Name,
+					version.Name,
+					version.ProvisioningState,
+					formatDate(version.PublishedDate, time.RFC3339),
+					version.ExcludeFromLatest,
+					formatDate(version.EndOfLifeDate, time.DateOnly),
+					formatRegions(version.Regions),
+					formatTags(version.Tags),
+				)
+			}
+		}
+	}
+
+	return tw.Flush()
+}
+
+func formatDate(date *time.Time, layout string) string {
+	if date == nil {
+		return "-"
+	}
+
+	return date.Format(layout)
+}
+
+func formatRegions(regions []Region) string {
+	if len(regions) == 0 {
+		return "-"
+	}
+
+	var parts []string
+	for _, region := range regions {
+		part := fmt.Sprintf("%s:%s", region.Name, region.State)
+		if region.State == string(armcompute.ReplicationStateReplicating) {
+			part = fmt.Sprintf("%s(%d%%)", part, region.Progress)
+		}
+		parts = append(parts, part)
+	}
+
+	return strings.Join(parts, ",")
+}
+
+func formatTags(tags map[string]string) string {
+	if len(tags) == 0 {
+		return "-"
+	}
+
+	var parts []string
+	for key, value := range tags {
+		parts = append(parts, key+"="+value)
+	}
+	slices.Sort(parts)
+
+	return strings.Join(parts, ",")
+}
@@ -1,11 +1,14 @@
 package managedidentity
 
 import (
+	"aib-pipeline-demo/internal/resourcetags"
 	"context"
 	"fmt"
 	"log"
+	"strings"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/msi/armmsi"
 )
 
@@ -13,6 +16,7 @@ type UserAssignedIdentityParams struct {
 	ResourceGroup string
 	Name          string
 	Location      string
+	Tags          map[string]*string
 }
 
 type IdentityData struct {
@@ -33,6 +37,13 @@ func EnsureUserManagedIdentity(subscriptionID string, cred azcore.TokenCredentia
 	getResponse, err := identityClient.Get(ctx, identityParams.ResourceGroup, identityParams.Name, nil)
 	if err == nil {
 		log.Println("Identity already exists:", identityParams.Name)
+		if changes := resourcetags.Drift(getResponse.Tags, identityParams.Tags); len(changes) > 0 {
+			log.Printf("Updating identity %s: %s\n", identityParams.Name, strings.Join(changes, ", "))
+			update := armmsi.IdentityUpdate{Tags: resourcetags.Merge(getResponse.Tags, identityParams.Tags)}
+			if _, err = identityClient.Update(ctx, identityParams.ResourceGroup, identityParams.Name, update, nil); err != nil {
+				return identityData, fmt.Errorf("failed to update identity tags: %w", err)
+			}
+		}
 		identityData.ID = *getResponse.ID
 		identityData.PrincipleID = *getResponse.Properties.PrincipalID
 		return identityData, nil
@@ -41,11 +52,37 @@ func EnsureUserManagedIdentity(subscriptionID string, cred azcore.TokenCredentia
 	return createUserManagedIdentity(*identityClient, identityParams)
 }
 
+func GetUserManagedIdentity(cred azcore.TokenCredential, identityID string) (IdentityData, error) {
+	ctx := context.Background()
+	identityData := IdentityData{}
+
+	resourceID, err := arm.ParseResourceID(identityID)
+	if err != nil {
+		return identityData, fmt.Errorf("invalid identity ID %s: %w", identityID, err)
+	}
+
+	clientFactory, err := armmsi.NewClientFactory(resourceID.SubscriptionID, cred, nil)
+	if err != nil {
+		return identityData, fmt.Errorf("failed to create client factory: %w", err)
+	}
+	identityClient := clientFactory.NewUserAssignedIdentitiesClient()
+
+	resp, err := identityClient.Get(ctx, resourceID.ResourceGroupName, resourceID.Name, nil)
+	if err != nil {
+		return identityData, fmt.Errorf("error retrieving identity %s: %w", identityID, err)
+	}
+
+	identityData.ID = *resp.ID
+	identityData.PrincipleID = *resp.Properties.PrincipalID
+	return identityData, nil
+}
+
 func createUserManagedIdentity(client armmsi.UserAssignedIdentitiesClient, identityParams UserAssignedIdentityParams) (IdentityData, error) {
 	ctx := context.Background()
 	identityData := IdentityData{}
 	identity := armmsi.Identity{
 		Location: &identityParams.Location,
+		Tags:     identityParams.Tags,
 	}
 	response, err := client.CreateOrUpdate(ctx, identityParams.ResourceGroup, identityParams.Name, identity, nil)
 	if err != nil {
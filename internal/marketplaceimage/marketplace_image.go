@@ -0,0 +1,140 @@
+package marketplaceimage
+
+import (
+	"aib-pipeline-demo/internal/deref"
+	"aib-pipeline-demo/internal/imageversion"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+)
+
+type Params struct {
+	Location  string
+	Publisher string
+	Offer     string
+	SKU       string
+	Version   string
+}
+
+func GetImage(subscriptionID string, cred azcore.TokenCredential, params Params) (armcompute.VirtualMachineImage, error) {
+	clientFactory, err := armcompute.NewClientFactory(subscriptionID, cred, nil)
+	if err != nil {
+		return armcompute.VirtualMachineImage{}, fmt.Errorf("failed to create client factory: %w", err)
+	}
+	client := clientFactory.NewVirtualMachineImagesClient()
+
+	version := params.Version
+	if version == "" || version == "latest" {
+		version, err = findLatestVersion(*client, params)
+		if err != nil {
+			return armcompute.VirtualMachineImage{}, err
+		}
+	}
+
+	ctx := context.Background()
+	resp, err := client.Get(ctx, params.Location, params.Publisher, params.Offer, params.SKU, version, nil)
+	if err != nil {
+		return armcompute.VirtualMachineImage{}, fmt.Errorf("error retrieving marketplace image: %w", err)
+	}
+
+	return resp.VirtualMachineImage, nil
+}
+
+func findLatestVersion(client armcompute.VirtualMachineImagesClient, params Params) (string, error) {
+	ctx := context.Background()
+	resp, err := client.List(ctx, params.Location, params.Publisher, params.Offer, params.SKU, nil)
+	if err != nil {
+		return "", fmt.Errorf("error listing marketplace image versions: %w", err)
+	}
+
+	latest := ""
+	for _, image := range resp.VirtualMachineImageResourceArray {
+		if image.Name == nil {
+			continue
+		}
+		if latest == "" || imageversion.CompareVersionNames(*image.Name, latest) > 0 {
+			latest = *image.Name
+		}
+	}
+
+	if latest == "" {
+		return "", fmt.Errorf("no versions found for marketplace image %s:%s:%s", params.Publisher, params.Offer, params.SKU)
+	}
+
+	return latest, nil
+}
+
+// BuildImageProperties takes the properties of an existing image definition and
+// overwrites every field which is determined by the marketplace image.
+func BuildImageProperties(existing armcompute.GalleryImageProperties, params Params, image armcompute.VirtualMachineImage) armcompute.GalleryImageProperties {
+	properties := existing
+	osState := armcompute.OperatingSystemStateTypesGeneralized
+	properties.OSState = &osState
+	properties.Identifier = &armcompute.GalleryImageIdentifier{
+		Publisher: &params.Publisher,
+		Offer:     &params.Offer,
+		SKU:       &params.SKU,
+	}
+	properties.PurchasePlan = nil
+
+	if image.Properties == nil {
+		return properties
+	}
+
+	if image.Properties.OSDiskImage != nil && image.Properties.OSDiskImage.OperatingSystem != nil {
+		osType := *image.Properties.OSDiskImage.OperatingSystem
+		properties.OSType = &osType
+	}
+
+	if image.Properties.HyperVGeneration != nil {
+		hyperVGeneration := armcompute.HyperVGeneration(*image.Properties.HyperVGeneration)
+		properties.HyperVGeneration = &hyperVGeneration
+	}
+
+	if image.Properties.Architecture != nil {
+		architecture := armcompute.Architecture(*image.Properties.Architecture)
+		properties.Architecture = &architecture
+	}
+
+	if plan := image.Properties.Plan; plan != nil {
+		properties.PurchasePlan = &armcompute.ImagePurchasePlan{
+			Name:      plan.Name,
+			Product:   plan.Product,
+			Publisher: plan.Publisher,
+		}
+	}
+
+	return properties
+}
+
+// CompareImageProperties lists every field of the image definition properties
+// which contradicts the properties generated from the marketplace image.
+func CompareImageProperties(existing armcompute.GalleryImageProperties, expected armcompute.GalleryImageProperties) []string {
+	var mismatches []string
+	check := func(field string, existingValue string, expectedValue string) {
+		if !strings.EqualFold(existingValue, expectedValue) {
+			mismatches = append(mismatches, fmt.Sprintf("%s is %q but the marketplace image has %q", field, existingValue, expectedValue))
+		}
+	}
+
+	check("osType", deref.String(existing.OSType), deref.String(expected.OSType))
+	check("osState", deref.String(existing.OSState), deref.String(expected.OSState))
+	check("hyperVGeneration", deref.String(existing.HyperVGeneration), deref.String(expected.HyperVGeneration))
+	check("architecture", deref.String(existing.Architecture), deref.String(expected.Architecture))
+
+	var existingPlan, expectedPlan armcompute.ImagePurchasePlan
+	if existing.PurchasePlan != nil {
+		existingPlan = *existing.PurchasePlan
+	}
+	if expected.PurchasePlan != nil {
+		expectedPlan = *expected.PurchasePlan
+	}
+	check("purchasePlan.name", deref.String(existingPlan.Name), deref.String(expectedPlan.Name))
+	check("purchasePlan.product", deref.String(existingPlan.Product), deref.String(expectedPlan.Product))
+	check("purchasePlan.publisher", deref.String(existingPlan.Publisher), deref.String(expectedPlan.Publisher))
+
+	return mismatches
+}
@@ -0,0 +1,134 @@
+package marketplaceterms
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+)
+
+const apiVersion = "2021-01-01"
+
+// agreementTerms keeps the agreement properties as a map so that the terms
+// returned by the API can be sent back unchanged when accepting them.
+type agreementTerms struct {
+	Properties map[string]any `json:"properties"`
+}
+
+func EnsureTermsAccepted(subscriptionID string, cred azcore.TokenCredential, plan *armcompute.ImagePurchasePlan, accept bool) error {
+	if plan == nil {
+		log.Println("No purchase plan set, skipping marketplace terms")
+		return nil
+	}
+
+	if plan.Name == nil || plan.Product == nil || plan.Publisher == nil {
+		return fmt.Errorf("purchase plan must set name, product and publisher")
+	}
+
+	client, err := arm.NewClient("aib-pipeline-demo/marketplaceterms", "v0.0.0", cred, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create client: %w", err)
+	}
+
+	urlPath := agreementPath(subscriptionID, *plan)
+	terms, err := getAgreement(*client, urlPath)
+	if err != nil {
+		return fmt.Errorf("error retrieving marketplace terms: %w", err)
+	}
+
+	if accepted, ok := terms.Properties["accepted"].(bool); ok && accepted {
+		log.Printf("Marketplace terms already accepted: %s %s %s\n", *plan.Publisher, *plan.Product, *plan.Name)
+		return nil
+	}
+
+	if !accept {
+		return fmt.Errorf("marketplace terms have not been accepted for plan %s, product %s, publisher %s", *plan.Name, *plan.Product, *plan.Publisher)
+	}
+
+	log.Printf("Accepting marketplace terms: %s %s %s\n", *plan.Publisher, *plan.Product, *plan.Name)
+	terms.Properties["accepted"] = true
+	if err = putAgreement(*client, urlPath, terms); err != nil {
+		return fmt.Errorf("error accepting marketplace terms: %w", err)
+	}
+
+	return nil
+}
+
+func agreementPath(subscriptionID string, plan armcompute.ImagePurchasePlan) string {
+	return strings.Join([]string{
+		"/subscriptions", url.PathEscape(subscriptionID),
+		"providers/Microsoft.MarketplaceOrdering/offerTypes/virtualmachine",
+		"publishers", url.PathEscape(*plan.Publisher),
+		"offers", url.PathEscape(*plan.Product),
+		"plans", url.PathEscape(*plan.Name),
+		"agreements/current",
+	}, "/")
+}
+
+func getAgreement(client arm.Client, urlPath string) (agreementTerms, error) {
+	var terms agreementTerms
+	ctx := context.Background()
+	req, err := runtime.NewRequest(ctx, http.MethodGet, runtime.JoinPaths(client.Endpoint(), urlPath))
+	if err != nil {
+		return terms, err
+	}
+	prepareRequest(req)
+
+	resp, err := client.Pipeline().Do(req)
+	if err != nil {
+		return terms, err
+	}
+
+	if !runtime.HasStatusCode(resp, http.StatusOK) {
+		return terms, runtime.NewResponseError(resp)
+	}
+
+	if err = runtime.UnmarshalAsJSON(resp, &terms); err != nil {
+		return terms, err
+	}
+
+	if terms.Properties == nil {
+		terms.Properties = make(map[string]any)
+	}
+
+	return terms, nil
+}
+
+func putAgreement(client arm.Client, urlPath string, terms agreementTerms) error {
+	ctx := context.Background()
+	req, err := runtime.NewRequest(ctx, http.MethodPut, runtime.JoinPaths(client.Endpoint(), urlPath))
+	if err != nil {
+		return err
+	}
+	prepareRequest(req)
+
+	if err = runtime.MarshalAsJSON(req, terms); err != nil {
+		return err
+	}
+
+	resp, err := client.Pipeline().Do(req)
+	if err != nil {
+		return err
+	}
+
+	if !runtime.HasStatusCode(resp, http.StatusOK) {
+		return runtime.NewResponseError(resp)
+	}
+
+	return nil
+}
+
+func prepareRequest(req *policy.Request) {
+	query := req.Raw().URL.Query()
+	query.Set("api-version", apiVersion)
+	req.Raw().URL.RawQuery = query.Encode()
+	req.Raw().Header["Accept"] = []string{"application/json"}
+}
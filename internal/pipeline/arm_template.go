@@ -0,0 +1,223 @@
+package pipeline
+
+import (
+	"aib-pipeline-demo/internal/armtemplate"
+	"aib-pipeline-demo/internal/deref"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+func parameter(name string) armtemplate.Expression {
+	return armtemplate.Expression{
+		ARM:   fmt.Sprintf("parameters('%s')", name),
+		Bicep: name,
+	}
+}
+
+// BuildARMTemplate builds a resource group deployment containing the same
+// resources create_all_resources creates. Resources outside of the resource
+// group cannot be part of it and are returned as warnings instead.
+func (config Config) BuildARMTemplate() (armtemplate.Template, []string, error) {
+	var warnings []string
+	var targetRegions []any
+	for _, region := range config.TargetRegions {
+		targetRegions = append(targetRegions, region)
+	}
+
+	template := armtemplate.Template{
+		Parameters: []armtemplate.Parameter{
+			{Name: "location", Type: "string", DefaultValue: config.Location, Description: "Location in which to deploy resources"},
+			{Name: "identityName", Type: "string", DefaultValue: IdentityName, Description: "Name of the user assigned identity used by Azure Image Builder"},
+			{Name: "galleryName", Type: "string", DefaultValue: config.GalleryName, Description: "Name of the image gallery"},
+			{Name: "imageName", Type: "string", DefaultValue: config.ImageName, Description: "Name of the image definition"},
+			{Name: "imageTemplateName", Type: "string", DefaultValue: config.ImageTemplateName, Description: "Name of the image template"},
+			{Name: "runOutputName", Type: "string", DefaultValue: config.RunOutputName, Description: "The Azure Image Builder output name"},
+			{Name: "targetRegions", Type: "array", DefaultValue: targetRegions, Description: "Regions to replicate the produced image to"},
+		},
+	}
+
+	identity := &armtemplate.Resource{
+		Symbol:       "identity",
+		Type:         "Microsoft.ManagedIdentity/userAssignedIdentities",
+		APIVersion:   "2023-01-31",
+		NameSegments: []string{parameter("identityName").ARM},
+		BicepName:    parameter("identityName").Bicep,
+		Body: armtemplate.Object{
+			{Key: "location", Value: parameter("location")},
+		},
+	}
+
+	rolePermissions, err := armtemplate.ObjectFromJSON(config.RolePermissions)
+	if err != nil {
+		return template, nil, fmt.Errorf("error converting role permissions: %w", err)
+	}
+
+	roleDefinitionName := fmt.Sprintf("guid(resourceGroup().id, '%s')", RoleName)
+	roleDefinition := &armtemplate.Resource{
+		Symbol:       "roleDefinition",
+		Type:         "Microsoft.Authorization/roleDefinitions",
+		APIVersion:   "2022-04-01",
+		NameSegments: []string{roleDefinitionName},
+		BicepName:    roleDefinitionName,
+		Body: armtemplate.Object{
+			{Key: "properties", Value: armtemplate.Object{
+				{Key: "roleName", Value: RoleName},
+				{Key: "description", Value: RoleDescription},
+				{Key: "type", Value: "CustomRole"},
+				{Key: "assignableScopes", Value: []any{armtemplate.Expression{ARM: "resourceGroup().id", Bicep: "resourceGroup().id"}}},
+				{Key: "permissions", Value: []any{rolePermissions}},
+			}},
+		},
+	}
+
+	roleAssignment := &armtemplate.Resource{
+		Symbol:       "roleAssignment",
+		Type:         "Microsoft.Authorization/roleAssignments",
+		APIVersion:   "2022-04-01",
+		NameSegments: []string{fmt.Sprintf("guid(resourceGroup().id, %s, '%s')", parameter("identityName").ARM, RoleName)},
+		BicepName:    fmt.Sprintf("guid(resourceGroup().id, %s, '%s')", parameter("identityName").Bicep, RoleName),
+		DependsOn:    []*armtemplate.Resource{identity, roleDefinition},
+		Body: armtemplate.Object{
+			{Key: "properties", Value: armtemplate.Object{
+				{Key: "roleDefinitionId", Value: roleDefinition.ID()},
+				{Key: "principalId", Value: armtemplate.Expression{
+					ARM:   fmt.Sprintf("reference(%s, '%s').principalId", identity.ID().ARM, identity.APIVersion),
+					Bicep: identity.Symbol + ".properties.principalId",
+				}},
+				{Key: "principalType", Value: "ServicePrincipal"},
+			}},
+		},
+	}
+
+	gallery := &armtemplate.Resource{
+		Symbol:       "gallery",
+		Type:         "Microsoft.Compute/galleries",
+		APIVersion:   "2024-03-03",
+		NameSegments: []string{parameter("galleryName").ARM},
+		BicepName:    parameter("galleryName").Bicep,
+		Body: armtemplate.Object{
+			{Key: "location", Value: parameter("location")},
+		},
+	}
+
+	imageProperties, err := armtemplate.ObjectFromJSON(config.ImageProperties)
+	if err != nil {
+		return template, nil, fmt.Errorf("error converting image properties: %w", err)
+	}
+
+	imageDefinition := &armtemplate.Resource{
+		Symbol:       "imageDefinition",
+		Type:         "Microsoft.Compute/galleries/images",
+		APIVersion:   "2024-03-03",
+		NameSegments: []string{parameter("galleryName").ARM, parameter("imageName").ARM},
+		BicepName:    parameter("imageName").Bicep,
+		Parent:       gallery,
+		DependsOn:    []*armtemplate.Resource{gallery},
+		Body: armtemplate.Object{
+			{Key: "location", Value: parameter("location")},
+			{Key: "properties", Value: imageProperties},
+		},
+	}
+
+	if config.StagingResourceGroup != "" {
+		template.Parameters = append(template.Parameters, armtemplate.Parameter{
+			Name:         "stagingResourceGroup",
+			Type:         "string",
+			DefaultValue: config.StagingResourceGroup,
+			Description:  "Name of the resource group used to stage the build",
+		})
+		warnings = append(warnings, fmt.Sprintf("the staging resource group %s and the identity's Contributor role assignment on it are not part of the template", config.StagingResourceGroup))
+	}
+
+	if config.SharingProfile != nil {
+		warnings = append(warnings, "the gallery sharing profile is not part of the template, it is applied through the gallery sharing profile API")
+	}
+
+	if config.BuildSettings.SubnetID != "" {
+		warnings = append(warnings, "the network role definition and its assignment on the virtual network are not part of the template")
+	}
+
+	imageTemplate := config.BuildImageTemplate("", "", "")
+	templateProperties, err := armtemplate.ObjectFromJSON(imageTemplate.Properties)
+	if err != nil {
+		return template, nil, fmt.Errorf("error converting image template properties: %w", err)
+	}
+
+	distribute, ok := templateProperties.Get("distribute").([]any)
+	if !ok || len(distribute) != 1 {
+		return template, nil, fmt.Errorf("image template must have exactly one distributor")
+	}
+	distributor, ok := distribute[0].(armtemplate.Object)
+	if !ok {
+		return template, nil, fmt.Errorf("invalid image template distributor")
+	}
+	if config.ImageVersion != "" {
+		template.Parameters = append(template.Parameters, armtemplate.Parameter{
+			Name:         "imageVersion",
+			Type:         "string",
+			DefaultValue: config.ImageVersion,
+			Description:  "The image version to create",
+		})
+		distributor = distributor.Set("galleryImageId", armtemplate.Expression{
+			ARM:   fmt.Sprintf("format('{0}/versions/{1}', %s, %s)", imageDefinition.ID().ARM, parameter("imageVersion").ARM),
+			Bicep: fmt.Sprintf("'${%s}/versions/${%s}'", imageDefinition.ID().Bicep, parameter("imageVersion").Bicep),
+		})
+	} else {
+		distributor = distributor.Set("galleryImageId", imageDefinition.ID())
+	}
+	distributor = distributor.Set("runOutputName", parameter("runOutputName"))
+	distributor = distributor.Set("targetRegions", armtemplate.Expression{
+		ARM:   fmt.Sprintf("map(%s, lambda('region', createObject('name', lambdaVariables('region'))))", parameter("targetRegions").ARM),
+		Bicep: fmt.Sprintf("map(%s, region => { name: region })", parameter("targetRegions").Bicep),
+	})
+	distribute[0] = distributor
+
+	if config.StagingResourceGroup != "" {
+		templateProperties = templateProperties.Set("stagingResourceGroup", armtemplate.Expression{
+			ARM:   fmt.Sprintf("format('/subscriptions/{0}/resourceGroups/{1}', subscription().subscriptionId, %s)", parameter("stagingResourceGroup").ARM),
+			Bicep: fmt.Sprintf("format('/subscriptions/{0}/resourceGroups/{1}', subscription().subscriptionId, %s)", parameter("stagingResourceGroup").Bicep),
+		})
+	}
+
+	imageTemplateResource := &armtemplate.Resource{
+		Symbol:       "imageTemplate",
+		Type:         "Microsoft.VirtualMachineImages/imageTemplates",
+		APIVersion:   "2024-02-01",
+		NameSegments: []string{parameter("imageTemplateName").ARM},
+		BicepName:    parameter("imageTemplateName").Bicep,
+		DependsOn:    []*armtemplate.Resource{identity, imageDefinition, roleAssignment},
+		Body: armtemplate.Object{
+			{Key: "location", Value: parameter("location")},
+			{Key: "identity", Value: armtemplate.Object{
+				{Key: "type", Value: "UserAssigned"},
+				{Key: "userAssignedIdentities", Value: armtemplate.Object{
+					{Key: identity.ID(), Value: armtemplate.Object{}},
+				}},
+			}},
+			{Key: "properties", Value: templateProperties},
+		},
+	}
+
+	template.Resources = []*armtemplate.Resource{identity, roleDefinition, roleAssignment, gallery, imageDefinition, imageTemplateResource}
+
+	if len(config.Tags) > 0 {
+		var tags armtemplate.Object
+		for _, key := range slices.Sorted(maps.Keys(config.Tags)) {
+			tags = append(tags, armtemplate.Field{Key: key, Value: deref.String(config.Tags[key])})
+		}
+		template.Parameters = append(template.Parameters, armtemplate.Parameter{
+			Name:         "tags",
+			Type:         "object",
+			DefaultValue: tags,
+			Description:  "Tags to set on every resource and on the produced image versions",
+		})
+
+		for _, resource := range []*armtemplate.Resource{identity, gallery, imageDefinition, imageTemplateResource} {
+			resource.Body = resource.Body.Set("tags", parameter("tags"))
+		}
+		distribute[0] = distributor.Set("artifactTags", parameter("tags"))
+	}
+
+	return template, warnings, nil
+}
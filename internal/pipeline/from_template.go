@@ -0,0 +1,265 @@
+package pipeline
+
+import (
+	"aib-pipeline-demo/internal/buildprofile"
+	"aib-pipeline-demo/internal/deref"
+	"aib-pipeline-demo/internal/imagebuilder"
+	"aib-pipeline-demo/internal/imageversion"
+	"aib-pipeline-demo/internal/resourcetags"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/virtualmachineimagebuilder/armvirtualmachineimagebuilder/v2"
+)
+
+// ConfigFromImageTemplate builds the configuration from which
+// create_all_resources deploys an equivalent of an existing image template.
+// Settings it cannot reproduce are returned as warnings, and templates which
+// cannot be represented at all return an error.
+func ConfigFromImageTemplate(template armvirtualmachineimagebuilder.ImageTemplate, imageProperties armcompute.GalleryImageProperties) (Config, []string, error) {
+	var warnings []string
+	config := Config{
+		ImageProperties: imageProperties,
+	}
+
+	properties := template.Properties
+	if template.Location == nil || properties == nil {
+		return config, nil, fmt.Errorf("image template has no location or properties")
+	}
+	config.Location = *template.Location
+	if template.Name != nil {
+		config.ImageTemplateName = *template.Name
+	}
+
+	for _, identityID := range imagebuilder.ImageTemplateIdentityIDs(template) {
+		if resourceID, err := arm.ParseResourceID(identityID); err != nil || resourceID.Name != IdentityName {
+			warnings = append(warnings, fmt.Sprintf("the template uses the identity %s, create_all_resources uses an identity named %s in the resource group", identityID, IdentityName))
+		}
+	}
+
+	if len(properties.Distribute) != 1 {
+		return config, nil, fmt.Errorf("image template must have exactly one distributor, found %d", len(properties.Distribute))
+	}
+	distributor, ok := properties.Distribute[0].(*armvirtualmachineimagebuilder.ImageTemplateSharedImageDistributor)
+	if !ok || distributor.GalleryImageID == nil {
+		return config, nil, fmt.Errorf("image template must distribute to a gallery image")
+	}
+
+	imageID, err := arm.ParseResourceID(*distributor.GalleryImageID)
+	if err != nil {
+		return config, nil, fmt.Errorf("invalid gallery image ID %s: %w", *distributor.GalleryImageID, err)
+	}
+	if strings.EqualFold(imageID.ResourceType.String(), "Microsoft.Compute/galleries/images/versions") {
+		config.ImageVersion = imageID.Name
+		imageID = imageID.Parent
+	}
+	config.ImageName = imageID.Name
+	config.GalleryName = imageID.Parent.Name
+	// create_all_resources creates the gallery in its resource group and
+	// subscription, which are those of the template.
+	if template.ID != nil {
+		if templateID, err := arm.ParseResourceID(*template.ID); err == nil && (!strings.EqualFold(templateID.SubscriptionID, imageID.SubscriptionID) || !strings.EqualFold(templateID.ResourceGroupName, imageID.ResourceGroupName)) {
+			warnings = append(warnings, fmt.Sprintf("the gallery %s is in resource group %s of subscription %s, create_all_resources uses the gallery of that name in its own resource group and subscription", config.GalleryName, imageID.ResourceGroupName, imageID.SubscriptionID))
+		}
+	}
+
+	if distributor.RunOutputName != nil {
+		config.RunOutputName = *distributor.RunOutputName
+	}
+	for _, region := range distributor.TargetRegions {
+		if region.Name == nil {
+			continue
+		}
+		config.TargetRegions = append(config.TargetRegions, *region.Name)
+		if (region.ReplicaCount != nil && *region.ReplicaCount != 1) || (region.StorageAccountType != nil && *region.StorageAccountType != armvirtualmachineimagebuilder.SharedImageStorageAccountTypeStandardLRS) {
+			warnings = append(warnings, fmt.Sprintf("the replica count and storage account type of region %s are not kept", *region.Name))
+		}
+	}
+	if distributor.ExcludeFromLatest != nil {
+		config.ExcludeFromLatest = *distributor.ExcludeFromLatest
+	}
+	config.Tags = template.Tags
+	if len(resourcetags.Drift(template.Tags, distributor.ArtifactTags)) > 0 || len(resourcetags.Drift(distributor.ArtifactTags, template.Tags)) > 0 {
+		warnings = append(warnings, "the artifact tags of the distributor differ from the template tags, create_all_resources sets the template tags on both")
+	}
+	if distributor.Versioning != nil {
+		warnings = append(warnings, "the versioning of the distributor is not kept")
+	}
+
+	source, ok := properties.Source.(*armvirtualmachineimagebuilder.ImageTemplatePlatformImageSource)
+	if !ok {
+		return config, nil, fmt.Errorf("image template must use a marketplace image as its source")
+	}
+	identifier := imageProperties.Identifier
+	if identifier == nil || !strings.EqualFold(deref.String(identifier.Publisher), deref.String(source.Publisher)) || !strings.EqualFold(deref.String(identifier.Offer), deref.String(source.Offer)) || !strings.EqualFold(deref.String(identifier.SKU), deref.String(source.SKU)) {
+		warnings = append(warnings, fmt.Sprintf("the source image %s:%s:%s differs from the image definition identifier, which create_all_resources uses as the source", deref.String(source.Publisher), deref.String(source.Offer), deref.String(source.SKU)))
+	}
+	if !planInfoEqual(source.PlanInfo, imageProperties.PurchasePlan) {
+		warnings = append(warnings, fmt.Sprintf("the source plan %s differs from the image definition purchase plan %s, which create_all_resources uses as the source plan and cannot be changed on an existing definition", describePlanInfo(source.PlanInfo), describePurchasePlan(imageProperties.PurchasePlan)))
+	}
+	if source.Version != nil && !strings.EqualFold(*source.Version, "latest") {
+		warnings = append(warnings, fmt.Sprintf("the source image version %s is not kept, create_all_resources always uses latest", *source.Version))
+	}
+
+	for i, customizer := range properties.Customize {
+		switch customizer.(type) {
+		case *armvirtualmachineimagebuilder.ImageTemplateShellCustomizer, *armvirtualmachineimagebuilder.ImageTemplateFileCustomizer:
+			config.Customizations = append(config.Customizations, customizer)
+		default:
+			return config, nil, fmt.Errorf("customizer %d has the type %s, only Shell and File customizers are supported", i, deref.String(customizer.GetImageTemplateCustomizer().Type))
+		}
+	}
+
+	if properties.Validate != nil {
+		for i, validator := range properties.Validate.InVMValidations {
+			switch validator.(type) {
+			case *armvirtualmachineimagebuilder.ImageTemplateShellValidator, *armvirtualmachineimagebuilder.ImageTemplatePowerShellValidator, *armvirtualmachineimagebuilder.ImageTemplateFileValidator:
+			default:
+				return config, nil, fmt.Errorf("validator %d has the type %s, only Shell, PowerShell and File validators are supported", i, deref.String(validator.GetImageTemplateInVMValidator().Type))
+			}
+		}
+		config.TemplateParams.Validate = properties.Validate
+	}
+
+	if properties.BuildTimeoutInMinutes != nil {
+		config.TemplateParams.BuildTimeoutInMinutes = *properties.BuildTimeoutInMinutes
+	}
+	if properties.ErrorHandling != nil {
+		if properties.ErrorHandling.OnCustomizerError != nil {
+			config.TemplateParams.OnCustomizerError = *properties.ErrorHandling.OnCustomizerError
+		}
+		if properties.ErrorHandling.OnValidationError != nil {
+			config.TemplateParams.OnValidationError = *properties.ErrorHandling.OnValidationError
+		}
+	}
+	if properties.StagingResourceGroup != nil && *properties.StagingResourceGroup != "" {
+		stagingGroupID, err := arm.ParseResourceID(*properties.StagingResourceGroup)
+		if err != nil {
+			return config, nil, fmt.Errorf("invalid staging resource group %s: %w", *properties.StagingResourceGroup, err)
+		}
+		config.StagingResourceGroup = stagingGroupID.ResourceGroupName
+	}
+
+	config.BuildSettings.SecurityType, err = buildprofile.ImageSecurityType(imageProperties)
+	if err != nil {
+		warnings = append(warnings, fmt.Sprintf("the image definition security type is not kept: %s", err))
+		config.BuildSettings.SecurityType = buildprofile.SecurityTypeStandard
+	}
+
+	if vmProfile := properties.VMProfile; vmProfile != nil {
+		config.BuildSettings.VMSize = deref.String(vmProfile.VMSize)
+		if vmProfile.OSDiskSizeGB != nil {
+			config.BuildSettings.OSDiskSizeGB = *vmProfile.OSDiskSizeGB
+		}
+		if vmProfile.VnetConfig != nil {
+			config.BuildSettings.SubnetID = deref.String(vmProfile.VnetConfig.SubnetID)
+			config.BuildSettings.ContainerInstanceSubnetID = deref.String(vmProfile.VnetConfig.ContainerInstanceSubnetID)
+			config.BuildSettings.ProxyVMSize = deref.String(vmProfile.VnetConfig.ProxyVMSize)
+		}
+		if len(vmProfile.UserAssignedIdentities) > 0 {
+			warnings = append(warnings, "the user assigned identities of the build VM are not kept")
+		}
+	}
+
+	if err = config.BuildSettings.ApplyToImageProperties(&config.ImageProperties); err != nil {
+		warnings = append(warnings, err.Error())
+	}
+	if err = config.BuildSettings.Validate(config.ImageProperties); err != nil {
+		warnings = append(warnings, fmt.Sprintf("create_all_resources will reject the build settings: %s", err))
+	}
+
+	return config, warnings, nil
+}
+
+func planInfoEqual(planInfo *armvirtualmachineimagebuilder.PlatformImagePurchasePlan, purchasePlan *armcompute.ImagePurchasePlan) bool {
+	if planInfo == nil || purchasePlan == nil {
+		return planInfo == nil && purchasePlan == nil
+	}
+
+	return strings.EqualFold(deref.String(planInfo.PlanName), deref.String(purchasePlan.Name)) &&
+		strings.EqualFold(deref.String(planInfo.PlanProduct), deref.String(purchasePlan.Product)) &&
+		strings.EqualFold(deref.String(planInfo.PlanPublisher), deref.String(purchasePlan.Publisher))
+}
+
+func describePlanInfo(planInfo *armvirtualmachineimagebuilder.PlatformImagePurchasePlan) string {
+	if planInfo == nil {
+		return "none"
+	}
+
+	return fmt.Sprintf("%s:%s:%s", deref.String(planInfo.PlanPublisher), deref.String(planInfo.PlanProduct), deref.String(planInfo.PlanName))
+}
+
+func describePurchasePlan(purchasePlan *armcompute.ImagePurchasePlan) string {
+	if purchasePlan == nil {
+		return "none"
+	}
+
+	return fmt.Sprintf("%s:%s:%s", deref.String(purchasePlan.Publisher), deref.String(purchasePlan.Product), deref.String(purchasePlan.Name))
+}
+
+// Args returns the command line flags which reproduce the configuration,
+// except for the paths of the config files.
+func (config Config) Args() []string {
+	args := []string{
+		"--location", config.Location,
+		"--imageTemplateName", config.ImageTemplateName,
+		"--galleryName", config.GalleryName,
+		"--imageName", config.ImageName,
+		"--runOutputName", config.RunOutputName,
+	}
+	for _, region := range config.TargetRegions {
+		args = append(args, "--targetRegion", region)
+	}
+
+	if config.ImageVersion != "" {
+		args = append(args, "--versionScheme", string(imageversion.SchemeExplicit), "--version", config.ImageVersion)
+	}
+
+	if config.ExcludeFromLatest {
+		args = append(args, "--excludeFromLatest")
+	}
+
+	settings := config.BuildSettings
+	if settings.SecurityType != "" && settings.SecurityType != buildprofile.SecurityTypeStandard {
+		args = append(args, "--securityType", string(settings.SecurityType))
+	}
+
+	optional := [][2]string{
+		{"--vmSize", settings.VMSize},
+		{"--subnetID", settings.SubnetID},
+		{"--containerInstanceSubnetID", settings.ContainerInstanceSubnetID},
+		{"--proxyVMSize", settings.ProxyVMSize},
+		{"--stagingResourceGroup", config.StagingResourceGroup},
+		{"--onCustomizerError", string(config.TemplateParams.OnCustomizerError)},
+		{"--onValidationError", string(config.TemplateParams.OnValidationError)},
+	}
+	if settings.OSDiskSizeGB != 0 {
+		optional = append(optional, [2]string{"--osDiskSizeGB", strconv.Itoa(int(settings.OSDiskSizeGB))})
+	}
+	if config.TemplateParams.BuildTimeoutInMinutes != 0 {
+		optional = append(optional, [2]string{"--buildTimeout", strconv.Itoa(int(config.TemplateParams.BuildTimeoutInMinutes))})
+	}
+	for _, flag := range optional {
+		if flag[1] != "" {
+			args = append(args, flag[0], flag[1])
+		}
+	}
+
+	for _, tag := range resourcetags.Args(config.Tags) {
+		args = append(args, "--tag", tag)
+	}
+
+	if validate := config.TemplateParams.Validate; validate != nil {
+		if validate.ContinueDistributeOnFailure != nil && *validate.ContinueDistributeOnFailure {
+			args = append(args, "--continueDistributeOnFailure")
+		}
+		if validate.SourceValidationOnly != nil && *validate.SourceValidationOnly {
+			args = append(args, "--sourceValidationOnly")
+		}
+	}
+
+	return args
+}
@@ -0,0 +1,410 @@
+package pipeline
+
+import (
+	"aib-pipeline-demo/internal/buildprofile"
+	"aib-pipeline-demo/internal/imagebuilder"
+	"aib-pipeline-demo/internal/imagedefinition"
+	"aib-pipeline-demo/internal/imagegallery"
+	"aib-pipeline-demo/internal/imageversion"
+	"aib-pipeline-demo/internal/resourcetags"
+	"aib-pipeline-demo/internal/role"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/authorization/armauthorization/v2"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/virtualmachineimagebuilder/armvirtualmachineimagebuilder/v2"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	IdentityName           = "aibUserIdentity"
+	RoleName               = "AIB Role Definition"
+	RoleDescription        = "Role to give Azure Image Builder access to the necessary resources."
+	networkRoleName        = "AIB Network Role Definition"
+	NetworkRoleDescription = "Role to give Azure Image Builder access to the build virtual network."
+)
+
+// Config holds everything needed to build the image definition and image
+// template, shared by the commands which build them from the config files.
+type Config struct {
+	Location             string
+	ImageTemplateName    string
+	GalleryName          string
+	ImageName            string
+	RunOutputName        string
+	TargetRegions        []string
+	StagingResourceGroup string
+	VersionScheme        imageversion.Scheme
+	// ImageVersion is empty when Azure Image Builder numbers the version.
+	ImageVersion      string
+	ExcludeFromLatest bool
+	// SharingProfile is nil when the gallery's sharing is not managed.
+	SharingProfile *armcompute.SharingProfile
+	// Tags are set on every resource the pipeline creates and on the image
+	// versions it produces.
+	Tags map[string]*string
+
+	RolePermissions        armauthorization.Permission
+	NetworkRolePermissions armauthorization.Permission
+	ImageProperties        armcompute.GalleryImageProperties
+	BuildSettings          buildprofile.Settings
+	TemplateParams         imagebuilder.PropertiesParams
+	Customizations         []armvirtualmachineimagebuilder.ImageTemplateCustomizerClassification
+}
+
+func Flags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:     "location",
+			Aliases:  []string{"l"},
+			Usage:    "Location in which to deploy resources",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:     "imageTemplateName",
+			Usage:    "Name of the image template to create",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "runOutputName",
+			Usage: "The Azure Image Builder output name",
+			Value: "aibDemoOutput",
+		},
+		&cli.StringFlag{
+			Name:  "imageName",
+			Usage: "The name of the image definition to create",
+			Value: "aibDemoImage",
+		},
+		&cli.StringFlag{
+			Name:     "galleryName",
+			Usage:    "The name of the image gallery to create",
+			Required: true,
+		},
+		&cli.StringSliceFlag{
+			Name:     "targetRegion",
+			Aliases:  []string{"r"},
+			Usage:    "A region to replicate the produced image to.",
+			Required: true,
+		},
+		&cli.StringSliceFlag{
+			Name:  "cmkRequiredRegion",
+			Usage: "Region which requires a customer-managed key, which cannot be a target region of the Azure Image Builder distributor",
+		},
+		&cli.StringFlag{
+			Name:  "versionScheme",
+			Usage: "How the image version is numbered: auto by Azure Image Builder, explicit from --version, date as YYYY.MMDD.HHmm or git from the tag of HEAD",
+			Value: string(imageversion.SchemeAuto),
+		},
+		&cli.StringFlag{
+			Name:  "version",
+			Usage: "The major.minor.patch image version to create with the explicit versioning scheme",
+		},
+		&cli.BoolFlag{
+			Name:  "excludeFromLatest",
+			Usage: "Exclude the produced image versions from latest until they are promoted",
+			Value: false,
+		},
+		&cli.PathFlag{
+			Name:  "rolePermissions",
+			Value: "./config/aibRolePermissions.json",
+			Usage: "Path to the role permissions file",
+		},
+		&cli.PathFlag{
+			Name:  "networkRolePermissions",
+			Value: "./config/aibNetworkRolePermissions.json",
+			Usage: "Path to the role permissions file used on the virtual network when a subnet is set",
+		},
+		&cli.PathFlag{
+			Name:  "imageProperties",
+			Value: "./config/imageDefinitionProperties.json",
+			Usage: "Path to the image definitions properties file",
+		},
+		&cli.StringSliceFlag{
+			Name:  "tag",
+			Usage: "Tag in the format key=value to set on every resource, can be repeated and overrides --tags",
+		},
+		&cli.PathFlag{
+			Name:  "tags",
+			Usage: "Path to a JSON file of tags to set on every resource",
+		},
+		&cli.PathFlag{
+			Name:  "sharingProfile",
+			Usage: "Path to the gallery sharing profile file, the gallery's sharing is left unchanged if not set",
+		},
+		&cli.PathFlag{
+			Name:  "customizations",
+			Value: "./config/customizations.json",
+			Usage: "Path to the image template customizations file",
+		},
+		&cli.StringFlag{
+			Name:  "securityType",
+			Usage: "Security type of the image: Standard, TrustedLaunch, TrustedLaunchSupported, ConfidentialVM, ConfidentialVmSupported or TrustedLaunchAndConfidentialVmSupported, defaults to the SecurityType feature in the image properties file",
+		},
+		&cli.StringFlag{
+			Name:  "architecture",
+			Usage: "Architecture of the image: x64 or Arm64, defaults to the architecture in the image properties file",
+		},
+		&cli.StringFlag{
+			Name:  "vmSize",
+			Usage: "Size of the VM used to build the image, defaults to a size compatible with the architecture and security type",
+		},
+		&cli.IntFlag{
+			Name:  "osDiskSizeGB",
+			Usage: "Size of the OS disk of the build VM in GB, defaults to the size of the source image",
+		},
+		&cli.StringFlag{
+			Name:  "subnetID",
+			Usage: "Resource ID of an existing subnet to deploy the build VM in",
+		},
+		&cli.StringFlag{
+			Name:  "containerInstanceSubnetID",
+			Usage: "Resource ID of an existing subnet to deploy the isolated build container instance in, requires --subnetID",
+		},
+		&cli.StringFlag{
+			Name:  "proxyVMSize",
+			Usage: "Size of the proxy VM used to reach the build VM in the subnet, requires --subnetID",
+		},
+		&cli.IntFlag{
+			Name:  "buildTimeout",
+			Usage: "Maximum duration of an image build in minutes, defaults to the Azure Image Builder default of 240",
+		},
+		&cli.StringFlag{
+			Name:  "stagingResourceGroup",
+			Usage: "Name of the resource group used to stage the build, instead of an automatically named IT_ group",
+		},
+		&cli.StringFlag{
+			Name:  "onCustomizerError",
+			Usage: "Behavior when a customizer fails: abort to keep the build VM for debugging, or cleanup",
+		},
+		&cli.StringFlag{
+			Name:  "onValidationError",
+			Usage: "Behavior when a validation fails: abort to keep the build VM for debugging, or cleanup",
+		},
+		&cli.PathFlag{
+			Name:  "validations",
+			Usage: "Path to the image template validations file, no validation is done if not set",
+		},
+		&cli.BoolFlag{
+			Name:  "continueDistributeOnFailure",
+			Usage: "Distribute the image even if validation fails",
+			Value: false,
+		},
+		&cli.BoolFlag{
+			Name:  "sourceValidationOnly",
+			Usage: "Only validate the source image, without customizing or distributing it",
+			Value: false,
+		},
+	}
+}
+
+// ConfigFromContext reads the flags and config files and validates the
+// combination of settings without calling Azure.
+func ConfigFromContext(c *cli.Context) (Config, error) {
+	config := Config{
+		Location:             c.String("location"),
+		ImageTemplateName:    c.String("imageTemplateName"),
+		GalleryName:          c.String("galleryName"),
+		ImageName:            c.String("imageName"),
+		RunOutputName:        c.String("runOutputName"),
+		TargetRegions:        c.StringSlice("targetRegion"),
+		StagingResourceGroup: c.String("stagingResourceGroup"),
+		ExcludeFromLatest:    c.Bool("excludeFromLatest"),
+	}
+
+	err := imageversion.ValidateDistributorRegions(config.TargetRegions, c.StringSlice("cmkRequiredRegion"))
+	if err != nil {
+		return config, err
+	}
+
+	config.VersionScheme, err = imageversion.ParseScheme(c.String("versionScheme"))
+	if err != nil {
+		return config, fmt.Errorf("error parsing versioning scheme: %w", err)
+	}
+
+	config.ImageVersion, err = imageversion.ResolveVersion(config.VersionScheme, c.String("version"), time.Now())
+	if err != nil {
+		return config, fmt.Errorf("error resolving image version: %w", err)
+	}
+
+	securityType, err := buildprofile.ParseSecurityType(c.String("securityType"))
+	if err != nil {
+		return config, fmt.Errorf("error parsing security type: %w", err)
+	}
+
+	architecture, err := buildprofile.ParseArchitecture(c.String("architecture"))
+	if err != nil {
+		return config, fmt.Errorf("error parsing architecture: %w", err)
+	}
+
+	onCustomizerError, err := imagebuilder.ParseOnBuildError(c.String("onCustomizerError"))
+	if err != nil {
+		return config, fmt.Errorf("error parsing customizer error handling: %w", err)
+	}
+
+	onValidationError, err := imagebuilder.ParseOnBuildError(c.String("onValidationError"))
+	if err != nil {
+		return config, fmt.Errorf("error parsing validation error handling: %w", err)
+	}
+
+	config.BuildSettings = buildprofile.Settings{
+		SecurityType:              securityType,
+		Architecture:              architecture,
+		VMSize:                    c.String("vmSize"),
+		OSDiskSizeGB:              int32(c.Int("osDiskSizeGB")),
+		SubnetID:                  c.String("subnetID"),
+		ContainerInstanceSubnetID: c.String("containerInstanceSubnetID"),
+		ProxyVMSize:               c.String("proxyVMSize"),
+	}
+
+	config.RolePermissions, err = role.BuildRolePermissionsFromFile(c.Path("rolePermissions"))
+	if err != nil {
+		return config, fmt.Errorf("error importing role permissions from file: %w", err)
+	}
+
+	if config.BuildSettings.SubnetID != "" {
+		config.NetworkRolePermissions, err = role.BuildRolePermissionsFromFile(c.Path("networkRolePermissions"))
+		if err != nil {
+			return config, fmt.Errorf("error importing network role permissions from file: %w", err)
+		}
+	}
+
+	config.ImageProperties, err = imagedefinition.BuildImagePropertiesFromFile(c.Path("imageProperties"))
+	if err != nil {
+		return config, fmt.Errorf("error getting image properties: %w", err)
+	}
+
+	if config.ImageProperties.Identifier == nil || config.ImageProperties.Identifier.Publisher == nil || config.ImageProperties.Identifier.Offer == nil || config.ImageProperties.Identifier.SKU == nil {
+		return config, fmt.Errorf("image properties must set the identifier publisher, offer and sku")
+	}
+
+	if err = config.BuildSettings.ApplyToImageProperties(&config.ImageProperties); err != nil {
+		return config, err
+	}
+	if err = config.BuildSettings.Validate(config.ImageProperties); err != nil {
+		return config, fmt.Errorf("error validating build settings: %w", err)
+	}
+
+	config.Tags = map[string]*string{}
+	if tagsFile := c.Path("tags"); tagsFile != "" {
+		config.Tags, err = resourcetags.BuildTagsFromFile(tagsFile)
+		if err != nil {
+			return config, fmt.Errorf("error importing tags: %w", err)
+		}
+	}
+
+	flagTags, err := resourcetags.Parse(c.StringSlice("tag"))
+	if err != nil {
+		return config, fmt.Errorf("error parsing tags: %w", err)
+	}
+	config.Tags = resourcetags.Merge(config.Tags, flagTags)
+
+	if sharingProfileFile := c.Path("sharingProfile"); sharingProfileFile != "" {
+		sharingProfile, err := imagegallery.BuildSharingProfileFromFile(sharingProfileFile)
+		if err != nil {
+			return config, fmt.Errorf("error importing gallery sharing profile: %w", err)
+		}
+		config.SharingProfile = &sharingProfile
+	}
+
+	config.Customizations, err = imagebuilder.BuildImageTemplateCustomizationsFromFile(c.Path("customizations"))
+	if err != nil {
+		return config, fmt.Errorf("error importing customizations: %w", err)
+	}
+
+	var validations []armvirtualmachineimagebuilder.ImageTemplateInVMValidatorClassification
+	if validationsFile := c.Path("validations"); validationsFile != "" {
+		validations, err = imagebuilder.BuildImageTemplateValidationsFromFile(validationsFile)
+		if err != nil {
+			return config, fmt.Errorf("error importing validations: %w", err)
+		}
+	}
+
+	config.TemplateParams = imagebuilder.PropertiesParams{
+		BuildTimeoutInMinutes: int32(c.Int("buildTimeout")),
+		OnCustomizerError:     onCustomizerError,
+		OnValidationError:     onValidationError,
+		Validate:              imagebuilder.BuildImageTemplateValidate(validations, c.Bool("continueDistributeOnFailure"), c.Bool("sourceValidationOnly")),
+	}
+
+	return config, nil
+}
+
+// KeepsDeployedVersion reports whether the image version of an existing
+// template is kept, as run_image_builder resolves date and git versions
+// again for every build.
+func (config Config) KeepsDeployedVersion() bool {
+	return config.VersionScheme == imageversion.SchemeDate || config.VersionScheme == imageversion.SchemeGit
+}
+
+func (config Config) RoleProperties(scope string) armauthorization.RoleDefinitionProperties {
+	roleParams := role.DefinitionParams{
+		Name:        RoleName,
+		Description: RoleDescription,
+		Scopes:      []string{scope},
+	}
+
+	return role.BuildRoleProperties(roleParams, config.RolePermissions)
+}
+
+// NetworkRoleName returns the name of the network role of the virtual
+// network. Role names are unique in the tenant, so the name includes the
+// virtual network's name and part of the GUID derived from its ID.
+func NetworkRoleName(vnetID string) string {
+	return fmt.Sprintf("%s %s %s", networkRoleName, path.Base(vnetID), role.DefinitionID(vnetID, networkRoleName)[:8])
+}
+
+func (config Config) NetworkRoleProperties(scope string) armauthorization.RoleDefinitionProperties {
+	roleParams := role.DefinitionParams{
+		Name:        NetworkRoleName(scope),
+		Description: NetworkRoleDescription,
+		Scopes:      []string{scope},
+	}
+
+	return role.BuildRoleProperties(roleParams, config.NetworkRolePermissions)
+}
+
+// BuildExpectedImageTemplate builds the image template create_all_resources
+// deploys in the resource group, without looking up any of the resources it
+// references.
+func (config Config) BuildExpectedImageTemplate(subscriptionID string, resourceGroup string) armvirtualmachineimagebuilder.ImageTemplate {
+	identityID := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.ManagedIdentity/userAssignedIdentities/%s", subscriptionID, resourceGroup, IdentityName)
+	imageID := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/galleries/%s/images/%s", subscriptionID, resourceGroup, config.GalleryName, config.ImageName)
+
+	stagingGroupID := ""
+	if config.StagingResourceGroup != "" {
+		stagingGroupID = fmt.Sprintf("/subscriptions/%s/resourceGroups/%s", subscriptionID, config.StagingResourceGroup)
+	}
+
+	return config.BuildImageTemplate(identityID, imageID, stagingGroupID)
+}
+
+// BuildImageTemplate builds the image template exactly as create_all_resources
+// deploys it. The staging resource group ID is empty when none is used.
+func (config Config) BuildImageTemplate(identityID string, imageID string, stagingResourceGroupID string) armvirtualmachineimagebuilder.ImageTemplate {
+	identifier := config.ImageProperties.Identifier
+	distributorParams := imagebuilder.DistributorParams{
+		ImageID:           imageID,
+		RunOutputName:     config.RunOutputName,
+		TargetRegions:     config.TargetRegions,
+		Version:           config.ImageVersion,
+		ExcludeFromLatest: config.ExcludeFromLatest,
+		ArtifactTags:      config.Tags,
+	}
+	distributeTemplate := imagebuilder.BuildImageTemplateDistributor(distributorParams)
+	sourceTemplate := imagebuilder.BuildImageTemplateSource(*identifier.Offer, *identifier.Publisher, *identifier.SKU, "latest", config.ImageProperties.PurchasePlan)
+	vmProfile := imagebuilder.BuildImageTemplateVMProfile(config.BuildSettings.VMProfileParams())
+
+	templateParams := config.TemplateParams
+	templateParams.StagingResourceGroup = stagingResourceGroupID
+
+	imageTemplateProperties := imagebuilder.BuildImageTemplateProperties(distributeTemplate, sourceTemplate, config.Customizations, vmProfile, templateParams)
+
+	imageTemplate := imagebuilder.BuildImageTemplate(identityID, config.Location, imageTemplateProperties)
+	if len(config.Tags) > 0 {
+		imageTemplate.Tags = config.Tags
+	}
+
+	return imageTemplate
+}
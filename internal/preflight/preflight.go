@@ -0,0 +1,352 @@
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/authorization/armauthorization/v2"
+)
+
+type Step struct {
+	Name    string
+	Actions []string
+}
+
+type MissingPermission struct {
+	Step   string
+	Action string
+}
+
+func CreateAllResourcesSteps() []Step {
+	return []Step{
+		{
+			Name: "Source image",
+			Actions: []string{
+				"Microsoft.Compute/locations/publishers/artifacttypes/offers/skus/versions/read",
+			},
+		},
+		{
+			Name: "Resource group",
+			Actions: []string{
+				"Microsoft.Resources/subscriptions/resourceGroups/read",
+				"Microsoft.Resources/subscriptions/resourceGroups/write",
+			},
+		},
+		{
+			Name: "Managed identity",
+			Actions: []string{
+				"Microsoft.ManagedIdentity/userAssignedIdentities/read",
+				"Microsoft.ManagedIdentity/userAssignedIdentities/write",
+			},
+		},
+		{
+			Name: "Role definition",
+			Actions: []string{
+				"Microsoft.Authorization/roleDefinitions/read",
+				"Microsoft.Authorization/roleDefinitions/write",
+			},
+		},
+		{
+			Name: "Role assignment",
+			Actions: []string{
+				"Microsoft.Authorization/roleAssignments/read",
+				"Microsoft.Authorization/roleAssignments/write",
+			},
+		},
+		{
+			Name: "Image gallery",
+			Actions: []string{
+				"Microsoft.Compute/galleries/read",
+				"Microsoft.Compute/galleries/write",
+			},
+		},
+		{
+			Name: "Image definition",
+			Actions: []string{
+				"Microsoft.Compute/galleries/images/read",
+				"Microsoft.Compute/galleries/images/write",
+			},
+		},
+		{
+			Name: "Image template",
+			Actions: []string{
+				"Microsoft.VirtualMachineImages/imageTemplates/read",
+				"Microsoft.VirtualMachineImages/imageTemplates/write",
+				"Microsoft.ManagedIdentity/userAssignedIdentities/assign/action",
+			},
+		},
+	}
+}
+
+func RegisterProvidersStep(namespaces []string) Step {
+	actions := make([]string, len(namespaces))
+	for i, namespace := range namespaces {
+		actions[i] = namespace + "/register/action"
+	}
+
+	return Step{
+		Name:    "Resource provider registration",
+		Actions: actions,
+	}
+}
+
+// NetworkRoleStep holds the actions needed on the virtual network of the build
+// subnet, where the network role is defined and assigned to the identity.
+func NetworkRoleStep() Step {
+	return Step{
+		Name: "Network role",
+		Actions: []string{
+			"Microsoft.Authorization/roleDefinitions/read",
+			"Microsoft.Authorization/roleDefinitions/write",
+			"Microsoft.Authorization/roleAssignments/read",
+			"Microsoft.Authorization/roleAssignments/write",
+		},
+	}
+}
+
+// StagingResourceGroupStep holds the actions needed on the staging resource
+// group, which is created and where the identity is given the Contributor
+// role.
+func StagingResourceGroupStep() Step {
+	return Step{
+		Name: "Staging resource group",
+		Actions: []string{
+			"Microsoft.Resources/subscriptions/resourceGroups/read",
+			"Microsoft.Resources/subscriptions/resourceGroups/write",
+			"Microsoft.Authorization/roleAssignments/read",
+			"Microsoft.Authorization/roleAssignments/write",
+		},
+	}
+}
+
+func ImageVersionCheckStep() Step {
+	return Step{
+		Name: "Image version check",
+		Actions: []string{
+			"Microsoft.Compute/galleries/images/versions/read",
+		},
+	}
+}
+
+func GallerySharingStep() Step {
+	return Step{
+		Name: "Gallery sharing",
+		Actions: []string{
+			"Microsoft.Compute/galleries/share/action",
+		},
+	}
+}
+
+// MarketplaceTermsStep is needed on every run with a purchase plan, as the
+// agreement is read to check that the terms are accepted.
+func MarketplaceTermsStep() Step {
+	return Step{
+		Name: "Marketplace terms",
+		Actions: []string{
+			"Microsoft.MarketplaceOrdering/offertypes/publishers/offers/plans/agreements/read",
+		},
+	}
+}
+
+func AcceptMarketplaceTermsStep() Step {
+	return Step{
+		Name: "Accept marketplace terms",
+		Actions: []string{
+			"Microsoft.MarketplaceOrdering/offertypes/publishers/offers/plans/agreements/write",
+		},
+	}
+}
+
+// CheckPermissions compares the caller's effective permissions on the resource
+// group against the actions needed by each step. If the resource group does not
+// exist yet the permissions inherited from the subscription are used instead.
+func CheckPermissions(subscriptionID string, cred azcore.TokenCredential, resourceGroup string, steps []Step) ([]MissingPermission, error) {
+	permissions, err := listResourceGroupPermissions(subscriptionID, cred, resourceGroup)
+	if err != nil {
+		switch e := err.(type) {
+		case *azcore.ResponseError:
+			if e.StatusCode != 404 {
+				return nil, fmt.Errorf("error while retrieving resource group permissions: %w", e)
+			}
+			log.Println("Resource group does not exist yet, checking subscription permissions:", resourceGroup)
+			permissions, err = listSubscriptionPermissions(subscriptionID, cred)
+			if err != nil {
+				return nil, fmt.Errorf("error while retrieving subscription permissions: %w", err)
+			}
+		default:
+			return nil, fmt.Errorf("error while retrieving resource group permissions: %w", e)
+		}
+	}
+
+	return findMissing(permissions, steps), nil
+}
+
+// CheckResourcePermissions compares the caller's effective permissions on an
+// existing resource, such as a virtual network in another resource group,
+// against the actions needed by each step.
+func CheckResourcePermissions(cred azcore.TokenCredential, resourceID string, steps []Step) ([]MissingPermission, error) {
+	permissions, err := listResourcePermissions(cred, resourceID)
+	if err != nil {
+		return nil, fmt.Errorf("error while retrieving permissions on %s: %w", resourceID, err)
+	}
+
+	return findMissing(permissions, steps), nil
+}
+
+func findMissing(permissions []*armauthorization.Permission, steps []Step) []MissingPermission {
+	var missing []MissingPermission
+	for _, step := range steps {
+		for _, action := range step.Actions {
+			if !isActionAllowed(permissions, action) {
+				missing = append(missing, MissingPermission{Step: step.Name, Action: action})
+			}
+		}
+	}
+
+	return missing
+}
+
+func PrintMissingPermissions(w io.Writer, missing []MissingPermission) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STEP\tMISSING ACTION")
+	for _, m := range missing {
+		fmt.Fprintf(tw, "%s\t%s\n", m.Step, m.Action)
+	}
+
+	return tw.Flush()
+}
+
+func listResourceGroupPermissions(subscriptionID string, cred azcore.TokenCredential, resourceGroup string) ([]*armauthorization.Permission, error) {
+	clientFactory, err := armauthorization.NewClientFactory(subscriptionID, cred, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client factory: %w", err)
+	}
+	client := clientFactory.NewPermissionsClient()
+
+	ctx := context.Background()
+	var permissions []*armauthorization.Permission
+	pager := client.NewListForResourceGroupPager(resourceGroup, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, page.Value...)
+	}
+
+	return permissions, nil
+}
+
+func listResourcePermissions(cred azcore.TokenCredential, resourceID string) ([]*armauthorization.Permission, error) {
+	id, err := arm.ParseResourceID(resourceID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid resource ID %s: %w", resourceID, err)
+	}
+
+	parentPath := ""
+	if id.Parent != nil && id.Parent.ResourceType.Namespace == id.ResourceType.Namespace {
+		parentPath = strings.TrimPrefix(id.Parent.String(), fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/%s/", id.SubscriptionID, id.ResourceGroupName, id.ResourceType.Namespace))
+	}
+	types := id.ResourceType.Types
+
+	clientFactory, err := armauthorization.NewClientFactory(id.SubscriptionID, cred, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client factory: %w", err)
+	}
+	client := clientFactory.NewPermissionsClient()
+
+	ctx := context.Background()
+	var permissions []*armauthorization.Permission
+	pager := client.NewListForResourcePager(id.ResourceGroupName, id.ResourceType.Namespace, parentPath, types[len(types)-1], id.Name, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, page.Value...)
+	}
+
+	return permissions, nil
+}
+
+// listSubscriptionPermissions calls the permissions API directly as the SDK only
+// exposes it at resource group and resource scope.
+func listSubscriptionPermissions(subscriptionID string, cred azcore.TokenCredential) ([]*armauthorization.Permission, error) {
+	client, err := arm.NewClient("aib-pipeline-demo/preflight", "v0.0.0", cred, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client: %w", err)
+	}
+
+	ctx := context.Background()
+	urlPath := "/subscriptions/" + url.PathEscape(subscriptionID) + "/providers/Microsoft.Authorization/permissions"
+	nextLink := ""
+	var permissions []*armauthorization.Permission
+	for {
+		resp, err := runtime.FetcherForNextLink(ctx, client.Pipeline(), nextLink, func(ctx context.Context) (*policy.Request, error) {
+			req, err := runtime.NewRequest(ctx, http.MethodGet, runtime.JoinPaths(client.Endpoint(), urlPath))
+			if err != nil {
+				return nil, err
+			}
+			reqQP := req.Raw().URL.Query()
+			reqQP.Set("api-version", "2022-04-01")
+			req.Raw().URL.RawQuery = reqQP.Encode()
+			req.Raw().Header["Accept"] = []string{"application/json"}
+			return req, nil
+		}, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var result armauthorization.PermissionGetResult
+		if err = runtime.UnmarshalAsJSON(resp, &result); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, result.Value...)
+
+		if result.NextLink == nil || *result.NextLink == "" {
+			return permissions, nil
+		}
+		nextLink = *result.NextLink
+	}
+}
+
+func isActionAllowed(permissions []*armauthorization.Permission, action string) bool {
+	for _, permission := range permissions {
+		if matchesAny(permission.Actions, action) && !matchesAny(permission.NotActions, action) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchesAny(patterns []*string, action string) bool {
+	for _, pattern := range patterns {
+		if pattern != nil && matchesAction(*pattern, action) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchesAction(pattern string, action string) bool {
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	re := regexp.MustCompile("(?i)^" + strings.Join(parts, ".*") + "$")
+
+	return re.MatchString(action)
+}
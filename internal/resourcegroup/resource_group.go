@@ -1,9 +1,11 @@
 package resourcegroup
 
 import (
+	"aib-pipeline-demo/internal/resourcetags"
 	"context"
 	"fmt"
 	"log"
+	"strings"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources/v2"
@@ -12,6 +14,7 @@ import (
 type Params struct {
 	Name     string
 	Location string
+	Tags     map[string]*string
 }
 
 func EnsureResourceGroup(subscriptionID string, cred azcore.TokenCredential, params Params) (string, error) {
@@ -26,10 +29,21 @@ func EnsureResourceGroup(subscriptionID string, cred azcore.TokenCredential, par
 
 	if err == nil {
 		log.Println("Resource group already exists:", params.Name)
+		if changes := resourcetags.Drift(resp.Tags, params.Tags); len(changes) > 0 {
+			log.Printf("Updating resource group %s: %s\n", params.Name, strings.Join(changes, ", "))
+			patch := armresources.ResourceGroupPatchable{Tags: resourcetags.Merge(resp.Tags, params.Tags)}
+			if _, err = groupsClient.Update(ctx, params.Name, patch, nil); err != nil {
+				return "", fmt.Errorf("error while updating group tags: %w", err)
+			}
+		}
 		return *resp.ID, nil
 	}
 
-	createResp, err := groupsClient.CreateOrUpdate(ctx, params.Name, armresources.ResourceGroup{Location: &params.Location}, nil)
+	group := armresources.ResourceGroup{
+		Location: &params.Location,
+		Tags:     params.Tags,
+	}
+	createResp, err := groupsClient.CreateOrUpdate(ctx, params.Name, group, nil)
 	if err != nil {
 		return "", fmt.Errorf("error while creating group: %w", err)
 	}
@@ -0,0 +1,112 @@
+package resourceprovider
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources/v2"
+)
+
+const (
+	registeredState  = "Registered"
+	registeringState = "Registering"
+)
+
+func RequiredNamespaces() []string {
+	return []string{
+		"Microsoft.VirtualMachineImages",
+		"Microsoft.Compute",
+		"Microsoft.Storage",
+		"Microsoft.Network",
+		"Microsoft.ManagedIdentity",
+	}
+}
+
+func EnsureProvidersRegistered(subscriptionID string, cred azcore.TokenCredential, namespaces []string, register bool) error {
+	clientFactory, err := armresources.NewClientFactory(subscriptionID, cred, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create client factory: %w", err)
+	}
+	client := clientFactory.NewProvidersClient()
+
+	unregistered, registering, err := findUnregisteredProviders(*client, namespaces)
+	if err != nil {
+		return err
+	}
+
+	if len(unregistered) == 0 && len(registering) == 0 {
+		return nil
+	}
+
+	if len(unregistered) > 0 && !register {
+		return fmt.Errorf("resource providers are not registered: %s", strings.Join(unregistered, ", "))
+	}
+
+	for _, namespace := range unregistered {
+		log.Println("Registering resource provider:", namespace)
+		if _, err = client.Register(context.Background(), namespace, nil); err != nil {
+			return fmt.Errorf("error registering resource provider %s: %w", namespace, err)
+		}
+	}
+
+	timeout := 10 * time.Minute
+	waitTime := 15 * time.Second
+	return waitForRegistration(*client, append(unregistered, registering...), timeout, waitTime)
+}
+
+// findUnregisteredProviders returns the providers which are not registered
+// and the providers which are still registering.
+func findUnregisteredProviders(client armresources.ProvidersClient, namespaces []string) ([]string, []string, error) {
+	ctx := context.Background()
+	var unregistered, registering []string
+	for _, namespace := range namespaces {
+		resp, err := client.Get(ctx, namespace, nil)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error retrieving resource provider %s: %w", namespace, err)
+		}
+
+		state := ""
+		if resp.RegistrationState != nil {
+			state = *resp.RegistrationState
+		}
+
+		switch state {
+		case registeredState:
+		case registeringState:
+			log.Printf("Resource provider %s is registering\n", namespace)
+			registering = append(registering, namespace)
+		default:
+			log.Printf("Resource provider %s is not registered, state: %s\n", namespace, state)
+			unregistered = append(unregistered, namespace)
+		}
+	}
+
+	return unregistered, registering, nil
+}
+
+func waitForRegistration(client armresources.ProvidersClient, namespaces []string, timeout, waitTime time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		unregistered, registering, err := findUnregisteredProviders(client, namespaces)
+		if err != nil {
+			return err
+		}
+
+		pending := append(unregistered, registering...)
+		if len(pending) == 0 {
+			log.Println("Resource providers registered:", strings.Join(namespaces, ", "))
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timeout after %s while registering resource providers: %s", timeout, strings.Join(pending, ", "))
+		}
+
+		time.Sleep(waitTime)
+	}
+}
@@ -0,0 +1,94 @@
+package resourcetags
+
+import (
+	"aib-pipeline-demo/internal/deref"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Parse reads tags in the format key=value.
+func Parse(values []string) (map[string]*string, error) {
+	tags := map[string]*string{}
+	for _, value := range values {
+		key, tagValue, found := strings.Cut(value, "=")
+		if !found || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("tag must be in the format key=value, got: %s", value)
+		}
+		tags[strings.TrimSpace(key)] = &tagValue
+	}
+
+	return tags, nil
+}
+
+// BuildTagsFromFile reads tags from a JSON object of tag names and values.
+func BuildTagsFromFile(path string) (map[string]*string, error) {
+	var tags map[string]*string
+	tagsData, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading file: %w", err)
+	}
+
+	if err = json.Unmarshal(tagsData, &tags); err != nil {
+		return nil, fmt.Errorf("error importing from json: %w", err)
+	}
+
+	return tags, nil
+}
+
+// Drift describes the desired tags which are missing from or have a
+// different value in the existing tags. Tags which are only in the existing
+// tags are not managed by the pipeline and are not drift. Tag names are case
+// insensitive in Azure, so keys are compared case insensitively.
+func Drift(existing map[string]*string, desired map[string]*string) []string {
+	var changes []string
+	for _, key := range slices.Sorted(maps.Keys(desired)) {
+		existingKey, ok := findKey(existing, key)
+		switch {
+		case !ok:
+			changes = append(changes, fmt.Sprintf("tag %s added", key))
+		case deref.String(existing[existingKey]) != deref.String(desired[key]):
+			changes = append(changes, fmt.Sprintf("tag %s changed from %q to %q", key, deref.String(existing[existingKey]), deref.String(desired[key])))
+		}
+	}
+
+	return changes
+}
+
+// Merge returns the existing tags with the desired tags set, replacing
+// existing tags whose name differs only in case.
+func Merge(existing map[string]*string, desired map[string]*string) map[string]*string {
+	merged := map[string]*string{}
+	maps.Copy(merged, existing)
+	for key, value := range desired {
+		if existingKey, ok := findKey(merged, key); ok {
+			delete(merged, existingKey)
+		}
+		merged[key] = value
+	}
+
+	return merged
+}
+
+func findKey(tags map[string]*string, key string) (string, bool) {
+	for tagKey := range tags {
+		if strings.EqualFold(tagKey, key) {
+			return tagKey, true
+		}
+	}
+
+	return "", false
+}
+
+// Args returns the tags as key=value values for the --tag flag, sorted by key.
+func Args(tags map[string]*string) []string {
+	var args []string
+	for _, key := range slices.Sorted(maps.Keys(tags)) {
+		args = append(args, key+"="+deref.String(tags[key]))
+	}
+
+	return args
+}
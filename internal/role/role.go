@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
@@ -13,12 +14,24 @@ import (
 	"github.com/google/uuid"
 )
 
+const contributorRoleDefinitionName = "b24988ac-6180-42a0-ab88-20f7382dd24c"
+
 type DefinitionParams struct {
 	Name        string
 	Description string
 	Scopes      []string
 }
 
+func ContributorRoleID(subscriptionID string) string {
+	return fmt.Sprintf("/subscriptions/%s/providers/Microsoft.Authorization/roleDefinitions/%s", subscriptionID, contributorRoleDefinitionName)
+}
+
+// DefinitionID returns the GUID of the role definition with the name at the
+// scope, which is the same on every run so a role is never created twice.
+func DefinitionID(scope, name string) string {
+	return uuid.NewSHA1(uuid.NameSpaceURL, []byte(strings.ToLower(scope)+"/"+name)).String()
+}
+
 func BuildRolePermissionsFromFile(path string) (armauthorization.Permission, error) {
 	var permissions armauthorization.Permission
 	rolePermissionData, err := os.ReadFile(path)
@@ -33,6 +46,19 @@ func BuildRolePermissionsFromFile(path string) (armauthorization.Permission, err
 	return permissions, nil
 }
 
+func ExportRolePermissionsToFile(path string, permissions armauthorization.Permission) error {
+	jsonData, err := json.MarshalIndent(permissions, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error marshaling data: %w", err)
+	}
+
+	if err = os.WriteFile(path, append(jsonData, '\n'), 0o644); err != nil {
+		return fmt.Errorf("error writing to file: %w", err)
+	}
+
+	return nil
+}
+
 func BuildRoleProperties(params DefinitionParams, permissions armauthorization.Permission) armauthorization.RoleDefinitionProperties {
 	scopePointerSlice := make([]*string, len(params.Scopes))
 	for i, str := range params.Scopes {
@@ -72,6 +98,44 @@ func EnsureRoleDefinition(subscriptionID string, cred azcore.TokenCredential, pr
 	return roleID, nil
 }
 
+// GetAssignedRoleDefinitions returns the definitions of all roles assigned to
+// the principal at the scope or inherited from above it.
+func GetAssignedRoleDefinitions(subscriptionID string, cred azcore.TokenCredential, scope, principalID string) ([]armauthorization.RoleDefinition, error) {
+	clientFactory, err := armauthorization.NewClientFactory(subscriptionID, cred, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client factory: %w", err)
+	}
+
+	ctx := context.Background()
+	assignmentClient := clientFactory.NewRoleAssignmentsClient()
+	definitionClient := clientFactory.NewRoleDefinitionsClient()
+
+	filter := fmt.Sprintf("principalId eq '%s'", principalID)
+	pager := assignmentClient.NewListForScopePager(scope, &armauthorization.RoleAssignmentsClientListForScopeOptions{Filter: &filter})
+
+	var definitions []armauthorization.RoleDefinition
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving role assignment page: %w", err)
+		}
+
+		for _, roleAssignment := range page.Value {
+			if roleAssignment.Properties == nil || roleAssignment.Properties.RoleDefinitionID == nil {
+				continue
+			}
+
+			resp, err := definitionClient.GetByID(ctx, *roleAssignment.Properties.RoleDefinitionID, nil)
+			if err != nil {
+				return nil, fmt.Errorf("error retrieving role definition %s: %w", *roleAssignment.Properties.RoleDefinitionID, err)
+			}
+			definitions = append(definitions, resp.RoleDefinition)
+		}
+	}
+
+	return definitions, nil
+}
+
 func findRoleDefinition(client armauthorization.RoleDefinitionsClient, properties armauthorization.RoleDefinitionProperties, scope string) (string, error) {
 	ctx := context.Background()
 	roleName := *properties.RoleName
@@ -100,7 +164,7 @@ func createRoleDefinition(client armauthorization.RoleDefinitionsClient, propert
 		Properties: &properties,
 	}
 
-	roleID := uuid.New().String()
+	roleID := DefinitionID(scope, *properties.RoleName)
 
 	resp, err := client.CreateOrUpdate(ctx, scope, roleID, roleDefinition, nil)
 	if err != nil {